@@ -0,0 +1,40 @@
+package rrconfig
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Config loaded by any of the structured loaders, keys are dotted paths as
+// in JsonConfig.Get
+type Config interface {
+	Get(key string) (interface{}, error)
+	GetString(key string) (string, error)
+	GetInt(key string) (int, error)
+	GetFloat64(key string) (float64, error)
+	GetBool(key string) (bool, error)
+	GetStringSlice(key string) ([]string, error)
+	GetInterfaceSlice(key string) ([]interface{}, error)
+	Dump() (string, error)
+}
+
+var (
+	_ Config = (*JsonConfig)(nil)
+	_ Config = (*YamlConfig)(nil)
+	_ Config = (*TomlConfig)(nil)
+)
+
+// Load config from path, the format is picked by extension,
+// .json, .yaml, .yml or .toml
+func LoadConfig(path string) (Config, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return LoadJsonConfigFromFile(path)
+	case ".yaml", ".yml":
+		return LoadYamlConfigFromFile(path)
+	case ".toml":
+		return LoadTomlConfigFromFile(path)
+	}
+	return nil, fmt.Errorf("unsupported config format %s", path)
+}
@@ -0,0 +1,30 @@
+package rrconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "c.JSON")
+	if err := ioutil.WriteFile(path, []byte(`{"a":{"b":"c"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetString("a.b"); err != nil || v != "c" {
+		t.Fatalf("a.b = %q, %v", v, err)
+	}
+	if _, err := LoadConfig(filepath.Join(dir, "c.ini")); err == nil {
+		t.Fatal("expect error for unsupported extension")
+	}
+}
@@ -4,13 +4,25 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// safe for concurrent use, maps and slices returned by Get are shared with the
+// config and must not be modified
 type JsonConfig struct {
-	m     map[string]interface{}
-	rb    []byte
+	mu   sync.RWMutex // guards m, a Sub uses the one of its root
+	m    map[string]interface{}
+	env  string      // prefix of env vars overriding keys, empty for none
+	root *JsonConfig // config this one is a Sub of, nil for none
 }
 
 func LoadJsonConfigFromFile(path string) (*JsonConfig, error) {
@@ -21,47 +33,500 @@ func LoadJsonConfigFromFile(path string) (*JsonConfig, error) {
 	return LoadJsonConfigFromBytes(b)
 }
 
+// Load the json stored under key in s, any rrstorage.StorageWrapper will do
+func LoadJsonConfigFromStorage(s interface {
+	Fetch(string) ([]byte, error)
+}, key string) (*JsonConfig, error) {
+	b, err := s.Fetch(key)
+	if err != nil {
+		return nil, err
+	}
+	return LoadJsonConfigFromBytes(b)
+}
+
 func LoadJsonConfigFromBytes(b []byte) (*JsonConfig, error) {
 	var jm map[string]interface{}
 	if err := json.Unmarshal(b, &jm); err != nil {
 		return nil, err
 	}
+	if jm == nil {
+		// literal null
+		jm = make(map[string]interface{})
+	}
 	s := &JsonConfig{
-		m:     jm,
-		rb:    b,
+		m: jm,
 	}
 	return s, nil
 }
 
+// mutex guarding m, shared with the root
+func (s *JsonConfig) lock() *sync.RWMutex {
+	if s.root != nil {
+		return &s.root.mu
+	}
+	return &s.mu
+}
+
+// Replace the config with the one in path, the current one is kept when
+// it can't be loaded, Subs taken before keep the old values
+func (s *JsonConfig) ReloadFromFile(path string) error {
+	if s.root != nil {
+		return fmt.Errorf("can't reload a Sub config")
+	}
+	c, err := LoadJsonConfigFromFile(path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m = c.m
+	return nil
+}
+
+// tab indented json of the live config
 func (s *JsonConfig) Dump() (string, error) {
 	var rj bytes.Buffer
-	if err := json.Indent(&rj, s.rb, "", "\t"); err != nil {
+	if err := s.DumpTo(&rj, true); err != nil {
 		return "", err
 	}
-	return string(rj.Bytes()), nil
+	return strings.TrimSuffix(rj.String(), "\n"), nil
+}
+
+// Write json of the live config to w, tab indented or compact
+func (s *JsonConfig) DumpTo(w io.Writer, indent bool) error {
+	mu := s.lock()
+	mu.RLock()
+	defer mu.RUnlock()
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if indent {
+		enc.SetIndent("", "\t")
+	}
+	return enc.Encode(s.m)
+}
+
+// Set("a.b[0].c", v), missing objects on the path are created, indices must
+// be within existing arrays, v is stored as encoding/json would decode it, so
+// the getters see float64 for numbers
+func (s *JsonConfig) Set(key string, value interface{}) error {
+	vb, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var v interface{}
+	if err := json.Unmarshal(vb, &v); err != nil {
+		return err
+	}
+	// object names and array indices, in order
+	var steps []interface{}
+	for _, node := range strings.Split(key, ".") {
+		name, indices, err := parseNode(node)
+		if err != nil {
+			return fmt.Errorf("invalid key %s, %s", key, err)
+		}
+		if name == "" && len(indices) == 0 {
+			return fmt.Errorf("invalid key %s, empty segment", key)
+		}
+		if name != "" {
+			steps = append(steps, name)
+		}
+		for _, i := range indices {
+			steps = append(steps, i)
+		}
+	}
+	mu := s.lock()
+	mu.Lock()
+	defer mu.Unlock()
+	_, err = setValue(s.m, steps, v, key)
+	return err
 }
 
-// Get("a.b.c")
+// store v under steps in cur, returns the new value of cur. Objects are
+// created on the way back so a failed Set changes nothing.
+func setValue(cur interface{}, steps []interface{}, v interface{}, key string) (interface{}, error) {
+	if len(steps) == 0 {
+		return v, nil
+	}
+	switch step := steps[0].(type) {
+	case string:
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("value before %s in key %s is not object", step, key)
+		}
+		child, ok := m[step]
+		if !ok && len(steps) > 1 {
+			if _, isIndex := steps[1].(int); isIndex {
+				return nil, fmt.Errorf("no array for %s in key %s", step, key)
+			}
+			child = make(map[string]interface{})
+		}
+		nv, err := setValue(child, steps[1:], v, key)
+		if err != nil {
+			return nil, err
+		}
+		m[step] = nv
+		return m, nil
+	case int:
+		a, ok := cur.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("value before [%d] in key %s is not array", step, key)
+		}
+		if step >= len(a) {
+			return nil, fmt.Errorf("index %d out of range in key %s, length %d", step, key, len(a))
+		}
+		nv, err := setValue(a[step], steps[1:], v, key)
+		if err != nil {
+			return nil, err
+		}
+		a[step] = nv
+		return a, nil
+	}
+	return nil, fmt.Errorf("invalid key %s", key)
+}
+
+// Deep merge other into the config, other wins on conflicts, objects present
+// on both sides are merged key by key, anything else, arrays included, is
+// replaced as a whole
+func (s *JsonConfig) Merge(other *JsonConfig) error {
+	// copy so the configs share nothing afterwards
+	omu := other.lock()
+	omu.RLock()
+	ob, err := json.Marshal(other.m)
+	omu.RUnlock()
+	if err != nil {
+		return err
+	}
+	var om map[string]interface{}
+	if err := json.Unmarshal(ob, &om); err != nil {
+		return err
+	}
+
+	mu := s.lock()
+	mu.Lock()
+	defer mu.Unlock()
+	mergeMap(s.m, om)
+	return nil
+}
+
+func mergeMap(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sv, ok := v.(map[string]interface{})
+		dv, okk := dst[k].(map[string]interface{})
+		if ok && okk {
+			mergeMap(dv, sv)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// Load the files in order, each merged over the ones before it
+func LoadAndMerge(paths ...string) (*JsonConfig, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no config path")
+	}
+	s, err := LoadJsonConfigFromFile(paths[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths[1:] {
+		other, err := LoadJsonConfigFromFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Merge(other); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Config of the object at key, keys are relative to it, the object is
+// shared so Set on either side shows on both, env vars bound to this
+// config apply with the key added to the prefix
+func (s *JsonConfig) Sub(key string) (*JsonConfig, error) {
+	mu := s.lock()
+	mu.RLock()
+	defer mu.RUnlock()
+	v, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("value for key %s is not object", key)
+	}
+	sub := &JsonConfig{
+		m:    m,
+		root: s,
+	}
+	if s.root != nil {
+		sub.root = s.root
+	}
+	if s.env != "" {
+		sub.env = s.env + "_" + envName(key)
+	}
+	return sub, nil
+}
+
+// Bind the value at key into out as encoding/json would, "" binds the whole
+// config, env vars bound with BindEnv are not applied
+func (s *JsonConfig) Unmarshal(key string, out interface{}) error {
+	mu := s.lock()
+	mu.RLock()
+	var v interface{} = s.m
+	if key != "" {
+		var err error
+		if v, err = s.get(key); err != nil {
+			mu.RUnlock()
+			return err
+		}
+	}
+	b, err := json.Marshal(v)
+	mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+// Write the config to path, indented
+func (s *JsonConfig) SaveToFile(path string) error {
+	d, err := s.Dump()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(d), 0644)
+}
+
+// ${VAR} in string values
+var envRefRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Replace ${VAR} in all string values with the env var, unset vars are
+// left as they are, or make it fail without changing anything when strict
+func (s *JsonConfig) ExpandEnv(strict bool) error {
+	mu := s.lock()
+	mu.Lock()
+	defer mu.Unlock()
+	if strict {
+		if err := checkEnvRefs(s.m, ""); err != nil {
+			return err
+		}
+	}
+	expandEnvRefs(s.m)
+	return nil
+}
+
+func checkEnvRefs(v interface{}, key string) error {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		for k, e := range vv {
+			sub := k
+			if key != "" {
+				sub = key + "." + k
+			}
+			if err := checkEnvRefs(e, sub); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, e := range vv {
+			if err := checkEnvRefs(e, key+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case string:
+		for _, ref := range envRefRegex.FindAllStringSubmatch(vv, -1) {
+			if _, ok := os.LookupEnv(ref[1]); !ok {
+				return fmt.Errorf("env %s for key %s not set", ref[1], key)
+			}
+		}
+	}
+	return nil
+}
+
+func expandEnvRefs(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		for k, e := range vv {
+			vv[k] = expandEnvRefs(e)
+		}
+	case []interface{}:
+		for i, e := range vv {
+			vv[i] = expandEnvRefs(e)
+		}
+	case string:
+		return envRefRegex.ReplaceAllStringFunc(vv, func(ref string) string {
+			if e, ok := os.LookupEnv(ref[2 : len(ref)-1]); ok {
+				return e
+			}
+			return ref
+		})
+	}
+	return v
+}
+
+// Let env vars override keys, key a.b.c is looked up as PREFIX_A_B_C
+// before the json, values from env are strings, the typed getters parse them,
+// slices are comma separated, call it before sharing the config
+func (s *JsonConfig) BindEnv(prefix string) {
+	s.env = strings.ToUpper(prefix)
+}
+
+// value of the env var bound to key
+func (s *JsonConfig) lookupEnv(key string) (string, bool) {
+	if s.env == "" {
+		return "", false
+	}
+	return os.LookupEnv(s.env + "_" + envName(key))
+}
+
+// a.b[0].c is A_B_0_C
+func envName(key string) string {
+	return strings.ToUpper(strings.NewReplacer(".", "_", "[", "_", "]", "").Replace(key))
+}
+
+// Get("a.b.c"), array elements are reached with indices, Get("a.b[0].c")
 func (s *JsonConfig) Get(key string) (interface{}, error) {
-	nodes := strings.Split(key, ".")
-	m := s.m
-	for i := 0; i < len(nodes); i++ {
-		if v, ok := m[nodes[i]]; ok {
-			if vv, okk := v.(map[string]interface{}); okk {
-				// not end
-				m = vv
+	mu := s.lock()
+	mu.RLock()
+	defer mu.RUnlock()
+	return s.get(key)
+}
+
+// Get with the lock held
+func (s *JsonConfig) get(key string) (interface{}, error) {
+	if v, ok := s.lookupEnv(key); ok {
+		return v, nil
+	}
+	var cur interface{} = s.m
+	for _, node := range strings.Split(key, ".") {
+		name, indices, err := parseNode(node)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s, %s", key, err)
+		}
+		if name == "" && len(indices) == 0 {
+			// a..b or a trailing dot
+			return nil, fmt.Errorf("invalid key %s, empty segment", key)
+		}
+		if name != "" {
+			m, ok := cur.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("no value for key %s", key)
+			}
+			if cur, ok = m[name]; !ok {
+				return nil, fmt.Errorf("no value for key %s", key)
+			}
+		}
+		for _, i := range indices {
+			a, ok := cur.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("value before [%d] in key %s is not array", i, key)
+			}
+			if i >= len(a) {
+				return nil, fmt.Errorf("index %d out of range in key %s, length %d", i, key, len(a))
+			}
+			cur = a[i]
+		}
+	}
+	return cur, nil
+}
+
+// Whether key resolves to a value, null included
+func (s *JsonConfig) Has(key string) bool {
+	_, err := s.Get(key)
+	return err == nil
+}
+
+// Check all keys resolve to non null values, the error lists every
+// missing one
+func (s *JsonConfig) Require(keys ...string) error {
+	var missing []string
+	for _, key := range keys {
+		if v, err := s.Get(key); err != nil || v == nil {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required keys %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// All leaf keys, sorted, arrays count as leaves
+func (s *JsonConfig) Keys() []string {
+	keys := []string{}
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for k, v := range m {
+			if vv, ok := v.(map[string]interface{}); ok {
+				walk(prefix+k+".", vv)
 			} else {
-				return v, nil
+				keys = append(keys, prefix+k)
 			}
-		} else {
-			return nil, fmt.Errorf("no value for key %s", key)
 		}
 	}
-	return m, nil
+	mu := s.lock()
+	mu.RLock()
+	walk("", s.m)
+	mu.RUnlock()
+	sort.Strings(keys)
+	return keys
+}
+
+// Names of the children of the object at prefix, sorted, "" for the top level,
+// nil when prefix is not an object
+func (s *JsonConfig) SubKeys(prefix string) []string {
+	mu := s.lock()
+	mu.RLock()
+	defer mu.RUnlock()
+	var v interface{} = s.m
+	if prefix != "" {
+		var err error
+		if v, err = s.get(prefix); err != nil {
+			return nil
+		}
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// split "b[0][1]" into "b" and 0, 1
+func parseNode(node string) (string, []int, error) {
+	i := strings.Index(node, "[")
+	if i < 0 {
+		return node, nil, nil
+	}
+	name, rest := node[:i], node[i:]
+	var indices []int
+	for rest != "" {
+		j := strings.Index(rest, "]")
+		if rest[0] != '[' || j < 0 {
+			return "", nil, fmt.Errorf("malformed index in %s", node)
+		}
+		n, err := strconv.Atoi(rest[1:j])
+		if err != nil || n < 0 {
+			return "", nil, fmt.Errorf("invalid index %s in %s", rest[1:j], node)
+		}
+		indices = append(indices, n)
+		rest = rest[j+1:]
+	}
+	return name, indices, nil
 }
 
 func (s *JsonConfig) GetStringSlice(key string) ([]string, error) {
 	empty := []string{}
+	if v, ok := s.lookupEnv(key); ok {
+		return strings.Split(v, ","), nil
+	}
 	f, err := s.Get(key)
 	if err != nil {
 		return empty, err
@@ -81,6 +546,88 @@ func (s *JsonConfig) GetStringSlice(key string) ([]string, error) {
 	return ss, nil
 }
 
+func (s *JsonConfig) GetFloat64Slice(key string) ([]float64, error) {
+	empty := []float64{}
+	if v, ok := s.lookupEnv(key); ok {
+		parts := strings.Split(v, ",")
+		fs := make([]float64, len(parts))
+		for i, p := range parts {
+			f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil {
+				return empty, fmt.Errorf("%s[%d] is not a number", key, i)
+			}
+			fs[i] = f
+		}
+		return fs, nil
+	}
+	f, err := s.Get(key)
+	if err != nil {
+		return empty, err
+	}
+	if _, ok := f.([]interface{}); !ok {
+		return empty, fmt.Errorf("value for key %s is not slice", key)
+	}
+	sf := f.([]interface{})
+	fs := make([]float64, len(sf))
+	for i, v := range sf {
+		if vv, ok := v.(float64); ok {
+			fs[i] = vv
+		} else {
+			return empty, fmt.Errorf("%s[%d] is not a number", key, i)
+		}
+	}
+	return fs, nil
+}
+
+// numbers are truncated as in GetInt
+func (s *JsonConfig) GetIntSlice(key string) ([]int, error) {
+	fs, err := s.GetFloat64Slice(key)
+	if err != nil {
+		return []int{}, err
+	}
+	is := make([]int, len(fs))
+	for i, f := range fs {
+		is[i] = int(f)
+	}
+	return is, nil
+}
+
+// copy of the object at key
+func (s *JsonConfig) GetStringMap(key string) (map[string]interface{}, error) {
+	mu := s.lock()
+	mu.RLock()
+	defer mu.RUnlock()
+	f, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := f.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("value for key %s is not object", key)
+	}
+	res := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res, nil
+}
+
+func (s *JsonConfig) GetStringMapString(key string) (map[string]string, error) {
+	m, err := s.GetStringMap(key)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		vv, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s.%s is not a string", key, k)
+		}
+		res[k] = vv
+	}
+	return res, nil
+}
+
 func (s *JsonConfig) GetString(key string) (string, error) {
 	f, err := s.Get(key)
 	if err != nil {
@@ -93,6 +640,13 @@ func (s *JsonConfig) GetString(key string) (string, error) {
 }
 
 func (s *JsonConfig) GetInt(key string) (int, error) {
+	if v, ok := s.lookupEnv(key); ok {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("value for key %s is not int", key)
+		}
+		return i, nil
+	}
 	f, err := s.Get(key)
 	if err != nil {
 		return 0, err
@@ -103,7 +657,64 @@ func (s *JsonConfig) GetInt(key string) (int, error) {
 	return int(f.(float64)), nil
 }
 
+// json numbers are float64, integers from 2^53 on can't be told apart
+const maxExactInt = 1 << 53
+
+// Get integer value, fractions and numbers from 2^53 on are errors rather than
+// truncated, values from env vars are parsed in the full int64 range
+func (s *JsonConfig) GetInt64(key string) (int64, error) {
+	if v, ok := s.lookupEnv(key); ok {
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("value for key %s is not int64", key)
+		}
+		return i, nil
+	}
+	f, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := f.(float64)
+	if !ok || v != math.Trunc(v) {
+		return 0, fmt.Errorf("value for key %s is not int64", key)
+	}
+	if v >= maxExactInt || v <= -maxExactInt {
+		return 0, fmt.Errorf("value for key %s out of exact integer range", key)
+	}
+	return int64(v), nil
+}
+
+// as GetInt64, negative values are errors
+func (s *JsonConfig) GetUint64(key string) (uint64, error) {
+	if v, ok := s.lookupEnv(key); ok {
+		i, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("value for key %s is not uint64", key)
+		}
+		return i, nil
+	}
+	f, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := f.(float64)
+	if !ok || v != math.Trunc(v) || v < 0 {
+		return 0, fmt.Errorf("value for key %s is not uint64", key)
+	}
+	if v >= maxExactInt {
+		return 0, fmt.Errorf("value for key %s out of exact integer range", key)
+	}
+	return uint64(v), nil
+}
+
 func (s *JsonConfig) GetFloat64(key string) (float64, error) {
+	if v, ok := s.lookupEnv(key); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0.0, fmt.Errorf("value for key %s is not float64", key)
+		}
+		return f, nil
+	}
 	f, err := s.Get(key)
 	if err != nil {
 		return 0.0, err
@@ -114,7 +725,115 @@ func (s *JsonConfig) GetFloat64(key string) (float64, error) {
 	return f.(float64), nil
 }
 
+// Get bool value, the strings "true"/"false" and the numbers 1/0 are
+// accepted too, any other value is an error
+func (s *JsonConfig) GetBool(key string) (bool, error) {
+	f, err := s.Get(key)
+	if err != nil {
+		return false, err
+	}
+	switch v := f.(type) {
+	case bool:
+		return v, nil
+	case string:
+		if v == "true" || v == "false" {
+			return v == "true", nil
+		}
+	case float64:
+		if v == 1 || v == 0 {
+			return v == 1, nil
+		}
+	}
+	return false, fmt.Errorf("value for key %s is not bool", key)
+}
+
+// Get duration from a string like "30s" or "5m", numbers are seconds,
+// a numeric string too, as env vars give
+func (s *JsonConfig) GetDuration(key string) (time.Duration, error) {
+	f, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	switch v := f.(type) {
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	case string:
+		if d, err := time.ParseDuration(v); err == nil {
+			return d, nil
+		}
+		if sec, err := strconv.ParseFloat(v, 64); err == nil {
+			return time.Duration(sec * float64(time.Second)), nil
+		}
+		return 0, fmt.Errorf("value for key %s is not a valid duration, %q", key, v)
+	}
+	return 0, fmt.Errorf("value for key %s is not duration", key)
+}
+
+// multipliers of size suffixes, K is 1000 and Ki 1024
+var sizeUnits = map[string]float64{
+	"":   1,
+	"K":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"KI": 1 << 10,
+	"MI": 1 << 20,
+	"GI": 1 << 30,
+	"TI": 1 << 40,
+}
+
+// Get size in bytes from a string like "50M", "2GB" or "512Ki", see sizeUnits,
+// a trailing B and case are ignored, numbers are bytes
+func (s *JsonConfig) GetBytes(key string) (int64, error) {
+	f, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	switch v := f.(type) {
+	case float64:
+		if v < 0 || v != math.Trunc(v) {
+			return 0, fmt.Errorf("value for key %s is not a valid size", key)
+		}
+		return int64(v), nil
+	case string:
+		n, err := parseBytes(v)
+		if err != nil {
+			return 0, fmt.Errorf("value for key %s is not a valid size, %q", key, v)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("value for key %s is not size", key)
+}
+
+func parseBytes(v string) (int64, error) {
+	u := strings.ToUpper(strings.TrimSpace(v))
+	u = strings.TrimSuffix(u, "B")
+	i := strings.IndexFunc(u, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(u)
+	}
+	mul, ok := sizeUnits[strings.TrimSpace(u[i:])]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit in %s", v)
+	}
+	n, err := strconv.ParseFloat(u[:i], 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(n * mul), nil
+}
+
 func (s *JsonConfig) GetInterfaceSlice(key string) ([]interface{}, error) {
+	if v, ok := s.lookupEnv(key); ok {
+		parts := strings.Split(v, ",")
+		res := make([]interface{}, len(parts))
+		for i, p := range parts {
+			res[i] = p
+		}
+		return res, nil
+	}
 	f, err := s.Get(key)
 	if err != nil {
 		return nil, err
@@ -125,3 +844,34 @@ func (s *JsonConfig) GetInterfaceSlice(key string) ([]interface{}, error) {
 	return f.([]interface{}), nil
 }
 
+// GetString, def when the key is missing or not a string
+func (s *JsonConfig) GetStringDefault(key, def string) string {
+	if v, err := s.GetString(key); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetInt, def when the key is missing or not an int
+func (s *JsonConfig) GetIntDefault(key string, def int) int {
+	if v, err := s.GetInt(key); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetBool, def when the key is missing or not a bool
+func (s *JsonConfig) GetBoolDefault(key string, def bool) bool {
+	if v, err := s.GetBool(key); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetFloat64, def when the key is missing or not a float64
+func (s *JsonConfig) GetFloat64Default(key string, def float64) float64 {
+	if v, err := s.GetFloat64(key); err == nil {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,667 @@
+package rrconfig
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/songtianyi/rrframework/storage"
+)
+
+func TestJsonConfigBindEnv(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"db":{"host":"localhost","port":3306,"ratio":0.5,"tags":["a"]},"name":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("APP_DB_HOST", "db.internal")
+	os.Setenv("APP_DB_PORT", "5432")
+	os.Setenv("APP_DB_RATIO", "0.25")
+	os.Setenv("APP_DB_TAGS", "b,c")
+	defer func() {
+		for _, k := range []string{"APP_DB_HOST", "APP_DB_PORT", "APP_DB_RATIO", "APP_DB_TAGS"} {
+			os.Unsetenv(k)
+		}
+	}()
+
+	// not bound yet, file values
+	if v, _ := c.GetString("db.host"); v != "localhost" {
+		t.Fatalf("db.host = %q before BindEnv", v)
+	}
+
+	c.BindEnv("app")
+	if v, err := c.GetString("db.host"); err != nil || v != "db.internal" {
+		t.Fatalf("db.host = %q, %v", v, err)
+	}
+	if v, err := c.GetInt("db.port"); err != nil || v != 5432 {
+		t.Fatalf("db.port = %d, %v", v, err)
+	}
+	if v, err := c.GetFloat64("db.ratio"); err != nil || v != 0.25 {
+		t.Fatalf("db.ratio = %v, %v", v, err)
+	}
+	if v, err := c.GetStringSlice("db.tags"); err != nil || len(v) != 2 || v[0] != "b" || v[1] != "c" {
+		t.Fatalf("db.tags = %v, %v", v, err)
+	}
+	// no env var, falls back to the file
+	if v, err := c.GetString("name"); err != nil || v != "x" {
+		t.Fatalf("name = %q, %v", v, err)
+	}
+
+	os.Setenv("APP_DB_PORT", "abc")
+	if _, err := c.GetInt("db.port"); err == nil {
+		t.Fatal("expect error for non int env value")
+	}
+}
+
+func TestJsonConfigSet(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"db":{"host":"localhost"},"name":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("db.port", 5432); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("cache.redis.addr", "127.0.0.1:6379"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("name.first", "y"); err == nil {
+		t.Fatal("expect error setting below a string")
+	}
+	if err := c.Set("bad", make(chan int)); err == nil {
+		t.Fatal("expect error for unmarshalable value")
+	}
+	if _, err := c.Get("bad"); err == nil {
+		t.Fatal("failed Set should leave no value")
+	}
+	if err := c.Set("x.y[0]", 1); err == nil {
+		t.Fatal("expect error indexing a missing array")
+	}
+	if _, err := c.Get("x"); err == nil {
+		t.Fatal("failed Set should create no objects")
+	}
+	if err := c.Set("a..b", 1); err == nil {
+		t.Fatal("expect error for an empty segment")
+	}
+
+	dir, err := ioutil.TempDir("", "rrconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "c.json")
+	if err := c.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	c, err = LoadJsonConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetInt("db.port"); err != nil || v != 5432 {
+		t.Fatalf("db.port = %d, %v", v, err)
+	}
+	if v, err := c.GetString("cache.redis.addr"); err != nil || v != "127.0.0.1:6379" {
+		t.Fatalf("cache.redis.addr = %q, %v", v, err)
+	}
+	if v, err := c.GetString("db.host"); err != nil || v != "localhost" {
+		t.Fatalf("db.host = %q, %v", v, err)
+	}
+}
+
+func TestJsonConfigGetBool(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"a":true,"b":false,"c":"true","d":"false","e":1,"f":0,"g":"yes","h":2,"i":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, expect := range map[string]bool{"a": true, "b": false, "c": true, "d": false, "e": true, "f": false} {
+		if v, err := c.GetBool(key); err != nil || v != expect {
+			t.Errorf("GetBool(%s) = %v, %v", key, v, err)
+		}
+	}
+	for _, key := range []string{"g", "h", "i", "missing"} {
+		if _, err := c.GetBool(key); err == nil {
+			t.Errorf("GetBool(%s) should fail", key)
+		}
+	}
+}
+
+func TestJsonConfigIndex(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"servers":[{"host":"a","port":1},{"host":"b","port":2}],"grid":[[1,2],[3,4]],"name":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetString("servers[1].host"); err != nil || v != "b" {
+		t.Fatalf("servers[1].host = %q, %v", v, err)
+	}
+	if v, err := c.GetInt("servers[0].port"); err != nil || v != 1 {
+		t.Fatalf("servers[0].port = %d, %v", v, err)
+	}
+	if v, err := c.GetInt("grid[1][0]"); err != nil || v != 3 {
+		t.Fatalf("grid[1][0] = %d, %v", v, err)
+	}
+	for _, key := range []string{"servers[2].host", "name[0]", "servers[x]", "servers[0", "servers[-1]", "name.first"} {
+		if _, err := c.Get(key); err == nil {
+			t.Errorf("Get(%s) should fail", key)
+		}
+	}
+
+	os.Setenv("APP_SERVERS_1_HOST", "c")
+	defer os.Unsetenv("APP_SERVERS_1_HOST")
+	c.BindEnv("app")
+	if v, err := c.GetString("servers[1].host"); err != nil || v != "c" {
+		t.Fatalf("servers[1].host with env = %q, %v", v, err)
+	}
+}
+
+func TestJsonConfigDefault(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"s":"x","i":3,"b":true,"f":0.5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.GetStringDefault("s", "d") != "x" || c.GetStringDefault("i", "d") != "d" || c.GetStringDefault("missing", "d") != "d" {
+		t.Fatal("GetStringDefault")
+	}
+	if c.GetIntDefault("i", 7) != 3 || c.GetIntDefault("s", 7) != 7 || c.GetIntDefault("missing", 7) != 7 {
+		t.Fatal("GetIntDefault")
+	}
+	if !c.GetBoolDefault("b", false) || !c.GetBoolDefault("s", true) || c.GetBoolDefault("missing", false) {
+		t.Fatal("GetBoolDefault")
+	}
+	if c.GetFloat64Default("f", 1) != 0.5 || c.GetFloat64Default("s", 1) != 1 || c.GetFloat64Default("missing", 1) != 1 {
+		t.Fatal("GetFloat64Default")
+	}
+}
+
+func TestJsonConfigHas(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"a":{"n":null,"f":false,"e":"","l":[1]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "a.n", "a.f", "a.e", "a.l[0]"} {
+		if !c.Has(key) {
+			t.Errorf("Has(%s) should be true", key)
+		}
+	}
+	for _, key := range []string{"b", "a.x", "a.l[1]", "a.n.x"} {
+		if c.Has(key) {
+			t.Errorf("Has(%s) should be false", key)
+		}
+	}
+}
+
+func TestJsonConfigKeys(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"z":1,"flags":{"b":true,"a":false},"db":{"pool":{"size":1},"tags":[1,2]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := strings.Join(c.Keys(), ","); keys != "db.pool.size,db.tags,flags.a,flags.b,z" {
+		t.Fatalf("Keys() = %s", keys)
+	}
+	if keys := strings.Join(c.SubKeys("flags"), ","); keys != "a,b" {
+		t.Fatalf("SubKeys(flags) = %s", keys)
+	}
+	if keys := strings.Join(c.SubKeys(""), ","); keys != "db,flags,z" {
+		t.Fatalf("SubKeys() = %s", keys)
+	}
+	if keys := c.SubKeys("z"); keys != nil {
+		t.Fatalf("SubKeys(z) = %v", keys)
+	}
+}
+
+func TestJsonConfigSub(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"db":{"host":"localhost","pool":{"size":1}},"name":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Sub("name"); err == nil {
+		t.Fatal("expect error for Sub of a string")
+	}
+	db, err := c.Sub("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.GetString("host"); err != nil || v != "localhost" {
+		t.Fatalf("host = %q, %v", v, err)
+	}
+	pool, err := db.Sub("pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Set("size", 8); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetInt("db.pool.size"); err != nil || v != 8 {
+		t.Fatalf("db.pool.size after Sub Set = %d, %v", v, err)
+	}
+	d, _ := c.Dump()
+	if !strings.Contains(d, `"size": 8`) {
+		t.Fatalf("Dump not updated, %s", d)
+	}
+
+	os.Setenv("APP_DB_HOST", "db.internal")
+	defer os.Unsetenv("APP_DB_HOST")
+	c.BindEnv("app")
+	db, _ = c.Sub("db")
+	if v := db.GetStringDefault("host", ""); v != "db.internal" {
+		t.Fatalf("host with env = %q", v)
+	}
+}
+
+func TestJsonConfigGetPath(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"a":{"b":{"c":1},"s":"x"},"top":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// leaf scalar
+	if v, err := c.Get("a.b.c"); err != nil || v != 1.0 {
+		t.Fatalf("a.b.c = %v, %v", v, err)
+	}
+	// leaf map is the node itself, not the root
+	v, err := c.Get("a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := v.(map[string]interface{}); !ok || len(m) != 1 || m["c"] != 1.0 {
+		t.Fatalf("a.b = %v", v)
+	}
+	v, err = c.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := v.(map[string]interface{}); !ok || len(m) != 2 || m["s"] != "x" {
+		t.Fatalf("a = %v", v)
+	}
+	// mid path errors
+	for _, key := range []string{"a.s.x", "top.x", "a.x.c", "a..b", "a.", ""} {
+		if _, err := c.Get(key); err == nil {
+			t.Errorf("Get(%q) should fail", key)
+		}
+	}
+}
+
+func TestJsonConfigGetDuration(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"a":"30s","b":"5m","c":2,"d":1.5,"e":"10","f":"abc","g":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, expect := range map[string]time.Duration{
+		"a": 30 * time.Second,
+		"b": 5 * time.Minute,
+		"c": 2 * time.Second,
+		"d": 1500 * time.Millisecond,
+		"e": 10 * time.Second,
+	} {
+		if d, err := c.GetDuration(key); err != nil || d != expect {
+			t.Errorf("GetDuration(%s) = %s, %v", key, d, err)
+		}
+	}
+	for _, key := range []string{"f", "g", "missing"} {
+		if _, err := c.GetDuration(key); err == nil {
+			t.Errorf("GetDuration(%s) should fail", key)
+		}
+	}
+}
+
+func TestJsonConfigNumberSlice(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"i":[1,2,3],"f":[0.5,1.5],"m":[1,"x"],"s":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetIntSlice("i"); err != nil || len(v) != 3 || v[2] != 3 {
+		t.Fatalf("GetIntSlice(i) = %v, %v", v, err)
+	}
+	if v, err := c.GetFloat64Slice("f"); err != nil || len(v) != 2 || v[1] != 1.5 {
+		t.Fatalf("GetFloat64Slice(f) = %v, %v", v, err)
+	}
+	if _, err := c.GetIntSlice("m"); err == nil || err.Error() != "m[1] is not a number" {
+		t.Fatalf("GetIntSlice(m) error = %v", err)
+	}
+	if _, err := c.GetFloat64Slice("s"); err == nil {
+		t.Fatal("GetFloat64Slice(s) should fail")
+	}
+
+	os.Setenv("APP_I", "4, 5")
+	defer os.Unsetenv("APP_I")
+	c.BindEnv("app")
+	if v, err := c.GetIntSlice("i"); err != nil || len(v) != 2 || v[1] != 5 {
+		t.Fatalf("GetIntSlice(i) with env = %v, %v", v, err)
+	}
+}
+
+func TestJsonConfigUnmarshal(t *testing.T) {
+	type DBConfig struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+	c, err := LoadJsonConfigFromBytes([]byte(`{"db":{"host":"localhost","port":3306},"name":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var db DBConfig
+	if err := c.Unmarshal("db", &db); err != nil {
+		t.Fatal(err)
+	}
+	if db.Host != "localhost" || db.Port != 3306 {
+		t.Fatalf("db = %+v", db)
+	}
+	var all struct {
+		DB   DBConfig `json:"db"`
+		Name string   `json:"name"`
+	}
+	if err := c.Unmarshal("", &all); err != nil {
+		t.Fatal(err)
+	}
+	if all.DB != db || all.Name != "x" {
+		t.Fatalf("all = %+v", all)
+	}
+	if err := c.Unmarshal("name", &db); err == nil {
+		t.Fatal("expect error binding a string into a struct")
+	}
+	if err := c.Unmarshal("missing", &db); err == nil {
+		t.Fatal("expect error for missing key")
+	}
+}
+
+func TestJsonConfigConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "c.json")
+	if err := ioutil.WriteFile(path, []byte(`{"db":{"host":"b","port":1}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadJsonConfigFromBytes([]byte(`{"db":{"host":"a","port":1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := c.Sub("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if v, err := c.GetString("db.host"); err != nil || (v != "a" && v != "b") {
+					t.Errorf("db.host = %q, %v", v, err)
+					return
+				}
+				c.Keys()
+				c.Dump()
+				db.GetInt("port")
+			}
+		}()
+	}
+	for j := 0; j < 50; j++ {
+		if err := c.Set("db.port", j); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Set("port", j); err != nil {
+			t.Fatal(err)
+		}
+		if j%10 == 0 {
+			if err := c.ReloadFromFile(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wg.Wait()
+	if err := db.ReloadFromFile(path); err == nil {
+		t.Fatal("expect error reloading a Sub")
+	}
+}
+
+func TestJsonConfigMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	base := filepath.Join(dir, "default.json")
+	env := filepath.Join(dir, "prod.json")
+	ioutil.WriteFile(base, []byte(`{"db":{"host":"localhost","port":3306,"pool":{"size":1,"idle":1}},"tags":["a","b","c"],"name":"x"}`), 0644)
+	ioutil.WriteFile(env, []byte(`{"db":{"host":"db.prod","pool":{"size":8}},"tags":["p"],"extra":true}`), 0644)
+
+	c, err := LoadAndMerge(base, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, expect := range map[string]interface{}{
+		"db.host":      "db.prod",
+		"db.port":      3306.0,
+		"db.pool.size": 8.0,
+		"db.pool.idle": 1.0,
+		"name":         "x",
+		"extra":        true,
+	} {
+		if v, err := c.Get(key); err != nil || v != expect {
+			t.Errorf("%s = %v, %v", key, v, err)
+		}
+	}
+	if v, _ := c.GetStringSlice("tags"); len(v) != 1 || v[0] != "p" {
+		t.Fatalf("tags should be replaced, got %v", v)
+	}
+	// merged in values are copies
+	other, _ := LoadJsonConfigFromFile(env)
+	c.Merge(other)
+	other.Set("db.pool.size", 9)
+	if v := c.GetIntDefault("db.pool.size", 0); v != 8 {
+		t.Fatalf("db.pool.size changed through other, %d", v)
+	}
+	d, _ := c.Dump()
+	if !strings.Contains(d, "db.prod") {
+		t.Fatalf("Dump not updated, %s", d)
+	}
+	if _, err := LoadAndMerge(); err == nil {
+		t.Fatal("expect error for no paths")
+	}
+}
+
+func TestJsonConfigExpandEnv(t *testing.T) {
+	os.Setenv("RR_DB_PASS", "secret")
+	defer os.Unsetenv("RR_DB_PASS")
+	load := func() *JsonConfig {
+		c, err := LoadJsonConfigFromBytes([]byte(`{"db":{"dsn":"user:${RR_DB_PASS}@host","hosts":["${RR_DB_PASS}"]},"other":"${RR_UNSET_VAR}","n":1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	c := load()
+	if err := c.ExpandEnv(false); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.GetString("db.dsn"); v != "user:secret@host" {
+		t.Fatalf("db.dsn = %q", v)
+	}
+	if v, _ := c.GetString("db.hosts[0]"); v != "secret" {
+		t.Fatalf("db.hosts[0] = %q", v)
+	}
+	if v, _ := c.GetString("other"); v != "${RR_UNSET_VAR}" {
+		t.Fatalf("other = %q", v)
+	}
+
+	c = load()
+	err := c.ExpandEnv(true)
+	if err == nil || !strings.Contains(err.Error(), "RR_UNSET_VAR") {
+		t.Fatalf("expect error naming the unset var, got %v", err)
+	}
+	if v, _ := c.GetString("db.dsn"); v != "user:${RR_DB_PASS}@host" {
+		t.Fatalf("failed strict ExpandEnv changed db.dsn to %q", v)
+	}
+}
+
+func TestLoadJsonConfigFromStorage(t *testing.T) {
+	st := rrstorage.CreateMemoryStorage()
+	if err := st.Save([]byte(`{"db":{"host":"localhost"}}`), "conf/app.json"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadJsonConfigFromStorage(st, "conf/app.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetString("db.host"); err != nil || v != "localhost" {
+		t.Fatalf("db.host = %q, %v", v, err)
+	}
+	if _, err := LoadJsonConfigFromStorage(st, "missing.json"); err == nil {
+		t.Fatal("expect error for a missing object")
+	}
+}
+
+func TestJsonConfigGetStringMap(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"labels":{"app":"web","tier":"front"},"flags":{"a":true,"b":"x"},"s":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := c.GetStringMap("flags")
+	if err != nil || len(m) != 2 || m["a"] != true {
+		t.Fatalf("flags = %v, %v", m, err)
+	}
+	// a copy
+	m["c"] = 1
+	if c.Has("flags.c") {
+		t.Fatal("GetStringMap result shares the config map")
+	}
+	ms, err := c.GetStringMapString("labels")
+	if err != nil || len(ms) != 2 || ms["tier"] != "front" {
+		t.Fatalf("labels = %v, %v", ms, err)
+	}
+	if _, err := c.GetStringMapString("flags"); err == nil || err.Error() != "flags.a is not a string" {
+		t.Fatalf("GetStringMapString(flags) error = %v", err)
+	}
+	if _, err := c.GetStringMap("s"); err == nil {
+		t.Fatal("GetStringMap(s) should fail")
+	}
+}
+
+func TestJsonConfigGetInt64(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"id":9007199254740991,"edge":9007199254740993,"big":9007199254740993000,"neg":-5,"frac":1.5,"s":"1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetInt64("id"); err != nil || v != 1<<53-1 {
+		t.Fatalf("GetInt64(id) = %d, %v", v, err)
+	}
+	if v, err := c.GetUint64("id"); err != nil || v != 1<<53-1 {
+		t.Fatalf("GetUint64(id) = %d, %v", v, err)
+	}
+	if v, err := c.GetInt64("neg"); err != nil || v != -5 {
+		t.Fatalf("GetInt64(neg) = %d, %v", v, err)
+	}
+	// edge decodes to 2^53, which many integers round to
+	for _, key := range []string{"edge", "big", "frac", "s", "missing"} {
+		if _, err := c.GetInt64(key); err == nil {
+			t.Errorf("GetInt64(%s) should fail", key)
+		}
+	}
+	for _, key := range []string{"edge", "big", "frac", "neg"} {
+		if _, err := c.GetUint64(key); err == nil {
+			t.Errorf("GetUint64(%s) should fail", key)
+		}
+	}
+
+	os.Setenv("APP_ID", "18446744073709551615")
+	defer os.Unsetenv("APP_ID")
+	c.BindEnv("app")
+	if v, err := c.GetUint64("id"); err != nil || v != 18446744073709551615 {
+		t.Fatalf("GetUint64(id) with env = %d, %v", v, err)
+	}
+}
+
+func TestJsonConfigGetBytes(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"a":"50M","b":"2GB","c":"512Ki","d":"1.5GiB","e":4096,"f":"100","g":"1 kb","h":"10X","i":"M","j":-1,"k":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, expect := range map[string]int64{
+		"a": 50e6,
+		"b": 2e9,
+		"c": 512 << 10,
+		"d": 3 << 29,
+		"e": 4096,
+		"f": 100,
+		"g": 1000,
+	} {
+		if v, err := c.GetBytes(key); err != nil || v != expect {
+			t.Errorf("GetBytes(%s) = %d, %v", key, v, err)
+		}
+	}
+	for _, key := range []string{"h", "i", "j", "k", "missing"} {
+		if _, err := c.GetBytes(key); err == nil {
+			t.Errorf("GetBytes(%s) should fail", key)
+		}
+	}
+}
+
+func TestJsonConfigRequire(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"db":{"host":"localhost","pass":null},"f":false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Require("db.host", "f", "db"); err != nil {
+		t.Fatal(err)
+	}
+	err = c.Require("db.host", "db.pass", "db.port", "name")
+	if err == nil || err.Error() != "missing required keys db.pass, db.port, name" {
+		t.Fatalf("Require error = %v", err)
+	}
+}
+
+func TestJsonConfigDumpTo(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"b":1,"a":{"x":"<y>"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set("a.z", true)
+	var buf bytes.Buffer
+	if err := c.DumpTo(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != `{"a":{"x":"<y>","z":true},"b":1}`+"\n" {
+		t.Fatalf("compact DumpTo = %s", buf.String())
+	}
+	d, err := c.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != "{\n\t\"a\": {\n\t\t\"x\": \"<y>\",\n\t\t\"z\": true\n\t},\n\t\"b\": 1\n}" {
+		t.Fatalf("Dump = %s", d)
+	}
+}
+
+func TestJsonConfigSetIndex(t *testing.T) {
+	c, err := LoadJsonConfigFromBytes([]byte(`{"servers":[{"host":"a"},{"host":"b"}],"ports":[1,2]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("servers[1].host", "z"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetString("servers[1].host"); err != nil || v != "z" {
+		t.Fatalf("servers[1].host = %q, %v", v, err)
+	}
+	if err := c.Set("ports[0]", 8080); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetInt("ports[0]"); err != nil || v != 8080 {
+		t.Fatalf("ports[0] = %d, %v", v, err)
+	}
+	for _, key := range []string{"servers[2].host", "ports[0].x", "servers.host", "ports[x]"} {
+		if err := c.Set(key, 1); err == nil {
+			t.Errorf("Set(%s) should fail", key)
+		}
+	}
+	// no "servers[1]" object next to the array
+	if len(c.Keys()) != 2 {
+		t.Fatalf("unexpected keys %v", c.Keys())
+	}
+}
@@ -0,0 +1,110 @@
+package rrconfig
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// toml config, tables become map[string]interface{} and numbers float64 as
+// in YamlConfig, datetimes are RFC3339 strings
+type TomlConfig struct {
+	*JsonConfig
+}
+
+func LoadTomlConfigFromFile(path string) (*TomlConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return LoadTomlConfigFromBytes(b)
+}
+
+func LoadTomlConfigFromBytes(b []byte) (*TomlConfig, error) {
+	var tm map[string]interface{}
+	if _, err := toml.Decode(string(b), &tm); err != nil {
+		return nil, err
+	}
+	nm, err := normalizeToml(tm)
+	if err != nil {
+		return nil, err
+	}
+	jm := nm.(map[string]interface{})
+	s := &TomlConfig{
+		JsonConfig: &JsonConfig{
+			m: jm,
+		},
+	}
+	return s, nil
+}
+
+// convert arrays of tables to []interface{}, numbers to float64 and
+// datetimes to strings
+func normalizeToml(v interface{}) (interface{}, error) {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, e := range vv {
+			ne, err := normalizeToml(e)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = ne
+		}
+		return m, nil
+	case []map[string]interface{}:
+		a := make([]interface{}, len(vv))
+		for i, e := range vv {
+			ne, err := normalizeToml(e)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = ne
+		}
+		return a, nil
+	case []interface{}:
+		a := make([]interface{}, len(vv))
+		for i, e := range vv {
+			ne, err := normalizeToml(e)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = ne
+		}
+		return a, nil
+	case int64:
+		return float64(vv), nil
+	case time.Time:
+		return vv.Format(time.RFC3339Nano), nil
+	case float64, string, bool:
+		return vv, nil
+	case nil:
+		// empty document
+		return make(map[string]interface{}), nil
+	}
+	return nil, fmt.Errorf("unsupported toml value %v", v)
+}
+
+// toml of the live config
+func (s *TomlConfig) Dump() (string, error) {
+	mu := s.lock()
+	mu.RLock()
+	defer mu.RUnlock()
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(s.m); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Write the config to path as toml
+func (s *TomlConfig) SaveToFile(path string) error {
+	d, err := s.Dump()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(d), 0644)
+}
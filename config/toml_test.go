@@ -0,0 +1,62 @@
+package rrconfig
+
+import (
+	"testing"
+)
+
+func TestTomlConfig(t *testing.T) {
+	c, err := LoadTomlConfigFromBytes([]byte(`
+name = "x"
+tags = ["a", "b"]
+
+[db]
+host = "localhost"
+port = 3306
+ratio = 0.5
+
+[db.pool]
+size = 10
+
+[[servers]]
+host = "a"
+
+[[servers]]
+host = "b"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetString("db.host"); err != nil || v != "localhost" {
+		t.Fatalf("db.host = %q, %v", v, err)
+	}
+	if v, err := c.GetInt("db.port"); err != nil || v != 3306 {
+		t.Fatalf("db.port = %d, %v", v, err)
+	}
+	if v, err := c.GetFloat64("db.ratio"); err != nil || v != 0.5 {
+		t.Fatalf("db.ratio = %v, %v", v, err)
+	}
+	if v, err := c.GetInt("db.pool.size"); err != nil || v != 10 {
+		t.Fatalf("db.pool.size = %d, %v", v, err)
+	}
+	if v, err := c.GetString("servers[1].host"); err != nil || v != "b" {
+		t.Fatalf("servers[1].host = %q, %v", v, err)
+	}
+	if v, err := c.GetStringSlice("tags"); err != nil || len(v) != 2 || v[0] != "a" {
+		t.Fatalf("tags = %v, %v", v, err)
+	}
+	if _, err := c.Get("servers[2].host"); err == nil {
+		t.Fatal("expect error for servers[2]")
+	}
+
+	d, err := c.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = LoadTomlConfigFromBytes([]byte(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetString("servers[0].host"); err != nil || v != "a" {
+		t.Fatalf("servers[0].host after Dump = %q, %v", v, err)
+	}
+}
@@ -0,0 +1,73 @@
+package rrconfig
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// how often watched files are checked, change before calling Watch
+var WatchInterval = time.Second
+
+// polls a json config file for changes
+type JsonWatcher struct {
+	path     string
+	onChange func(*JsonConfig, error)
+
+	once sync.Once
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Watch path and reload it when its size or modification time changes,
+// onChange gets the new config, or nil and the error when it can't be loaded,
+// callers keep using the config they have in that case
+func Watch(path string, onChange func(*JsonConfig, error)) (*JsonWatcher, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &JsonWatcher{
+		path:     path,
+		onChange: onChange,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.loop(fi)
+	return w, nil
+}
+
+func (w *JsonWatcher) loop(last os.FileInfo) {
+	defer close(w.done)
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(w.path)
+		if err != nil {
+			// report once, until the file is back
+			if last != nil {
+				w.onChange(nil, err)
+			}
+			last = nil
+			continue
+		}
+		if last != nil && fi.Size() == last.Size() && fi.ModTime().Equal(last.ModTime()) {
+			continue
+		}
+		last = fi
+		w.onChange(LoadJsonConfigFromFile(w.path))
+	}
+}
+
+// Stop watching, onChange is not called once this returns
+func (w *JsonWatcher) StopWatch() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
@@ -0,0 +1,84 @@
+package rrconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	interval := WatchInterval
+	WatchInterval = 10 * time.Millisecond
+	defer func() { WatchInterval = interval }()
+
+	dir, err := ioutil.TempDir("", "rrconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "c.json")
+	if err := ioutil.WriteFile(path, []byte(`{"a":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	type change struct {
+		c   *JsonConfig
+		err error
+	}
+	changes := make(chan change, 10)
+	w, err := Watch(path, func(c *JsonConfig, err error) {
+		changes <- change{c, err}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.StopWatch()
+
+	// mtime may not move within the same second on some file systems
+	modified := time.Now().Add(time.Hour)
+	write := func(b string) {
+		if err := ioutil.WriteFile(path, []byte(b), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modified = modified.Add(time.Second)
+		os.Chtimes(path, modified, modified)
+	}
+	next := func() change {
+		select {
+		case ch := <-changes:
+			return ch
+		case <-time.After(2 * time.Second):
+			t.Fatal("no change reported")
+		}
+		return change{}
+	}
+
+	write(`{"a":2}`)
+	ch := next()
+	if ch.err != nil || ch.c.GetIntDefault("a", 0) != 2 {
+		t.Fatalf("reload = %v, %v", ch.c, ch.err)
+	}
+	write(`{"a":`)
+	if ch = next(); ch.err == nil || ch.c != nil {
+		t.Fatal("expect parse error")
+	}
+	write(`{"a":3}`)
+	if ch = next(); ch.err != nil || ch.c.GetIntDefault("a", 0) != 3 {
+		t.Fatalf("reload = %v, %v", ch.c, ch.err)
+	}
+
+	w.StopWatch()
+	write(`{"a":4}`)
+	time.Sleep(5 * WatchInterval)
+	if len(changes) != 0 {
+		t.Fatal("change reported after StopWatch")
+	}
+}
+
+func TestWatchMissingFile(t *testing.T) {
+	if _, err := Watch("/nonexistent/c.json", func(*JsonConfig, error) {}); err == nil {
+		t.Fatal("expect error watching a missing file")
+	}
+}
@@ -0,0 +1,108 @@
+package rrconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// yaml config, values are normalized to what encoding/json produces, objects
+// are map[string]interface{} and numbers float64, so the JsonConfig getters
+// work unchanged
+type YamlConfig struct {
+	*JsonConfig
+}
+
+func LoadYamlConfigFromFile(path string) (*YamlConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return LoadYamlConfigFromBytes(b)
+}
+
+func LoadYamlConfigFromBytes(b []byte) (*YamlConfig, error) {
+	var ym interface{}
+	if err := yaml.Unmarshal(b, &ym); err != nil {
+		return nil, err
+	}
+	nm, err := normalizeYaml(ym)
+	if err != nil {
+		return nil, err
+	}
+	jm, ok := nm.(map[string]interface{})
+	if !ok && nm != nil {
+		return nil, fmt.Errorf("yaml root is not a map")
+	}
+	if jm == nil {
+		jm = make(map[string]interface{})
+	}
+	s := &YamlConfig{
+		JsonConfig: &JsonConfig{
+			m: jm,
+		},
+	}
+	return s, nil
+}
+
+// convert yaml maps to map[string]interface{} and numbers to float64
+func normalizeYaml(v interface{}) (interface{}, error) {
+	switch vv := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, e := range vv {
+			ks, ok := k.(string)
+			if !ok {
+				// keys like 1 or true
+				ks = fmt.Sprint(k)
+			}
+			ne, err := normalizeYaml(e)
+			if err != nil {
+				return nil, err
+			}
+			m[ks] = ne
+		}
+		return m, nil
+	case []interface{}:
+		a := make([]interface{}, len(vv))
+		for i, e := range vv {
+			ne, err := normalizeYaml(e)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = ne
+		}
+		return a, nil
+	case int:
+		return float64(vv), nil
+	case int64:
+		return float64(vv), nil
+	case uint64:
+		return float64(vv), nil
+	case float64, string, bool, nil:
+		return vv, nil
+	}
+	return nil, fmt.Errorf("unsupported yaml value %v", v)
+}
+
+// yaml of the live config
+func (s *YamlConfig) Dump() (string, error) {
+	mu := s.lock()
+	mu.RLock()
+	defer mu.RUnlock()
+	b, err := yaml.Marshal(s.m)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Write the config to path as yaml
+func (s *YamlConfig) SaveToFile(path string) error {
+	d, err := s.Dump()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(d), 0644)
+}
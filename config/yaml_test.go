@@ -0,0 +1,66 @@
+package rrconfig
+
+import (
+	"testing"
+)
+
+func TestYamlConfig(t *testing.T) {
+	c, err := LoadYamlConfigFromBytes([]byte(`
+name: x
+db:
+  host: localhost
+  port: 3306
+  ratio: 0.5
+  debug: true
+servers:
+  - host: a
+  - host: b
+tags: [a, b]
+1: one
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetString("db.host"); err != nil || v != "localhost" {
+		t.Fatalf("db.host = %q, %v", v, err)
+	}
+	if v, err := c.GetInt("db.port"); err != nil || v != 3306 {
+		t.Fatalf("db.port = %d, %v", v, err)
+	}
+	if v, err := c.GetFloat64("db.ratio"); err != nil || v != 0.5 {
+		t.Fatalf("db.ratio = %v, %v", v, err)
+	}
+	if v, err := c.GetBool("db.debug"); err != nil || !v {
+		t.Fatalf("db.debug = %v, %v", v, err)
+	}
+	if v, err := c.GetString("servers[1].host"); err != nil || v != "b" {
+		t.Fatalf("servers[1].host = %q, %v", v, err)
+	}
+	if v, err := c.GetStringSlice("tags"); err != nil || len(v) != 2 || v[1] != "b" {
+		t.Fatalf("tags = %v, %v", v, err)
+	}
+	if v, err := c.GetString("1"); err != nil || v != "one" {
+		t.Fatalf("1 = %q, %v", v, err)
+	}
+
+	if err := c.Set("db.port", 5432); err != nil {
+		t.Fatal(err)
+	}
+	d, err := c.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = LoadYamlConfigFromBytes([]byte(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetInt("db.port"); err != nil || v != 5432 {
+		t.Fatalf("db.port after Dump = %d, %v", v, err)
+	}
+}
+
+func TestYamlConfigNotMap(t *testing.T) {
+	if _, err := LoadYamlConfigFromBytes([]byte("- a\n- b\n")); err == nil {
+		t.Fatal("expect error for a yaml list")
+	}
+}
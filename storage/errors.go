@@ -0,0 +1,48 @@
+package rrstorage
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+var (
+	// object missing, match with errors.Is
+	ErrNotFound = errors.New("not exist")
+	// request rejected for its credentials or signature
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// unexpected status from the server, errors.Is matches ErrNotFound for 404
+// and ErrUnauthorized for 401 and 403
+type StorageError struct {
+	Op         string // request that failed, such as "put" or "uploadPart"
+	StatusCode int
+	Body       string
+}
+
+func (e *StorageError) Error() string {
+	if e.Body != "" {
+		return e.Body
+	}
+	return strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode)
+}
+
+func (e *StorageError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// error of op for resp, whose body was read into body
+func statusError(op string, resp *http.Response, body []byte) error {
+	return &StorageError{
+		Op:         op,
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+	}
+}
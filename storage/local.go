@@ -1,8 +1,10 @@
 package rrstorage
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -15,34 +17,116 @@ type LocalDiskStorage struct {
 func CreateLocalDiskStorage(dir string) StorageWrapper {
 	// create dir
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		_ = os.MkdirAll(dir, os.ModeDir)
+		_ = os.MkdirAll(dir, 0755)
 	}
 	// check dir
 	s := &LocalDiskStorage{
-		Dir: strings.TrimSuffix(dir, "/"),
+		Dir: filepath.Clean(dir),
 	}
 	return s
 }
 
+// path of filename under Dir, keys escaping Dir are rejected
+func (s *LocalDiskStorage) path(filename string) (string, error) {
+	p := filepath.Join(s.Dir, filepath.FromSlash(filename))
+	rel, err := filepath.Rel(s.Dir, p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid filename %s", filename)
+	}
+	return p, nil
+}
+
 // Do save binary
+// data is written to a temp file then renamed, readers never see a partial file
 func (s *LocalDiskStorage) Save(data []byte, filename string) error {
-
-	//open a file for writing
-	file, err := os.Create(s.Dir + "/" + filename)
+	p, err := s.path(filename)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	//open a temp file for writing
+	file, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".tmp")
+	if err != nil {
+		return err
+	}
+	// temp files are private, keep the mode os.Create used to give
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
 	if _, err := file.Write(data); err != nil {
-		return err 
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), p); err != nil {
+		os.Remove(file.Name())
+		return err
 	}
 	return nil
 }
 
 func (s *LocalDiskStorage) Fetch(filename string) ([]byte, error) {
-	b, err := ioutil.ReadFile(s.Dir + "/" + filename)
+	p, err := s.path(filename)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s %w", filename, ErrNotFound)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return b, nil
 }
+
+func (s *LocalDiskStorage) Delete(filename string) error {
+	p, err := s.path(filename)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s %w", filename, ErrNotFound)
+	}
+	return err
+}
+
+func (s *LocalDiskStorage) Exists(filename string) (bool, error) {
+	p, err := s.path(filename)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(p)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Rename src to dst, atomic within one file system
+func (s *LocalDiskStorage) Move(src, dst string) error {
+	sp, err := s.path(src)
+	if err != nil {
+		return err
+	}
+	dp, err := s.path(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(sp); os.IsNotExist(err) {
+		return fmt.Errorf("%s %w", src, ErrNotFound)
+	}
+	if err := os.MkdirAll(filepath.Dir(dp), 0755); err != nil {
+		return err
+	}
+	return os.Rename(sp, dp)
+}
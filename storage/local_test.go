@@ -0,0 +1,105 @@
+package rrstorage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalDiskStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := CreateLocalDiskStorage(filepath.Join(dir, "root"))
+
+	if err := s.Save([]byte("hello"), "a/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Fetch("a/b.txt")
+	if err != nil || string(b) != "hello" {
+		t.Fatalf("Fetch = %q, %v", b, err)
+	}
+	fi, err := os.Stat(filepath.Join(dir, "root", "a", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0644 {
+		t.Fatalf("saved file mode %v, expect 0644", fi.Mode().Perm())
+	}
+	// no temp file left behind
+	fs, _ := ioutil.ReadDir(filepath.Join(dir, "root", "a"))
+	if len(fs) != 1 {
+		t.Fatalf("expect 1 file in dir, got %d", len(fs))
+	}
+	if ok, err := s.Exists("a/b.txt"); err != nil || !ok {
+		t.Fatalf("Exists = %v, %v", ok, err)
+	}
+	if err := s.Delete("a/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.Exists("a/b.txt"); err != nil || ok {
+		t.Fatalf("Exists after Delete = %v, %v", ok, err)
+	}
+	if _, err := s.Fetch("a/b.txt"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Fetch after Delete = %v, expect ErrNotFound", err)
+	}
+	if err := s.Delete("a/b.txt"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Delete after Delete = %v, expect ErrNotFound", err)
+	}
+
+	if err := s.Save([]byte("hello"), "src.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Move("src.txt", "c/d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Exists("src.txt"); ok {
+		t.Fatal("src.txt should not exist after Move")
+	}
+	if b, err := s.Fetch("c/d.txt"); err != nil || string(b) != "hello" {
+		t.Fatalf("Fetch(c/d.txt) = %q, %v", b, err)
+	}
+	if err := s.Move("src.txt", "e.txt"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound moving missing file, got %v", err)
+	}
+	if err := s.Move("c/d.txt", "../x"); err == nil {
+		t.Fatal("expect error moving outside root")
+	}
+}
+
+func TestLocalDiskStorageTraversal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := CreateLocalDiskStorage(filepath.Join(dir, "root"))
+
+	for _, key := range []string{"../x", "a/../../x", "..", ""} {
+		if err := s.Save([]byte("x"), key); err == nil {
+			t.Errorf("Save(%q) should fail", key)
+		}
+		if _, err := s.Fetch(key); err == nil {
+			t.Errorf("Fetch(%q) should fail", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x")); !os.IsNotExist(err) {
+		t.Fatal("file written outside root")
+	}
+	// dots inside a name are fine
+	if err := s.Save([]byte("x"), "a..b"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalDiskStorageRootDir(t *testing.T) {
+	s := CreateLocalDiskStorage("/").(*LocalDiskStorage)
+	p, err := s.path("tmp/x")
+	if err != nil || p != "/tmp/x" {
+		t.Fatalf("path = %q, %v", p, err)
+	}
+}
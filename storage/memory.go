@@ -0,0 +1,70 @@
+package rrstorage
+
+import (
+	"fmt"
+	"sync"
+)
+
+// in-memory storage, a dependency free fake for tests
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+// Create a MemoryStorage instance
+func CreateMemoryStorage() StorageWrapper {
+	return &MemoryStorage{
+		objects: make(map[string][]byte),
+	}
+}
+
+// Do save binary, data is copied
+func (s *MemoryStorage) Save(data []byte, filename string) error {
+	b := make([]byte, len(data))
+	copy(b, data)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[filename] = b
+	return nil
+}
+
+func (s *MemoryStorage) Fetch(filename string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.objects[filename]
+	if !ok {
+		return nil, fmt.Errorf("%s %w", filename, ErrNotFound)
+	}
+	rb := make([]byte, len(b))
+	copy(rb, b)
+	return rb, nil
+}
+
+func (s *MemoryStorage) Delete(filename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[filename]; !ok {
+		return fmt.Errorf("%s %w", filename, ErrNotFound)
+	}
+	delete(s.objects, filename)
+	return nil
+}
+
+func (s *MemoryStorage) Exists(filename string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.objects[filename]
+	return ok, nil
+}
+
+func (s *MemoryStorage) Move(src, dst string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.objects[src]
+	if !ok {
+		return fmt.Errorf("%s %w", src, ErrNotFound)
+	}
+	delete(s.objects, src)
+	s.objects[dst] = b
+	return nil
+}
@@ -0,0 +1,52 @@
+package rrstorage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	s := CreateMemoryStorage()
+	data := []byte("hello")
+	if err := s.Save(data, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	data[0] = 'j'
+	b, err := s.Fetch("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte("hello")) {
+		t.Fatalf("fetched %q, expect hello", b)
+	}
+	if ok, _ := s.Exists("a.txt"); !ok {
+		t.Fatal("a.txt should exist")
+	}
+	if err := s.Delete("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Exists("a.txt"); ok {
+		t.Fatal("a.txt should not exist after Delete")
+	}
+	if _, err := s.Fetch("a.txt"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound fetching deleted object, got %v", err)
+	}
+	if err := s.Delete("a.txt"); err == nil {
+		t.Fatal("expect error deleting missing object")
+	}
+
+	s.Save([]byte("hello"), "src.txt")
+	if err := s.Move("src.txt", "dst.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Exists("src.txt"); ok {
+		t.Fatal("src.txt should not exist after Move")
+	}
+	if b, err := s.Fetch("dst.txt"); err != nil || string(b) != "hello" {
+		t.Fatalf("Fetch(dst.txt) = %q, %v", b, err)
+	}
+	if err := s.Move("src.txt", "dst.txt"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound moving missing object, got %v", err)
+	}
+}
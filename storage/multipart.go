@@ -0,0 +1,93 @@
+package rrstorage
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Upload parts returned by next concurrently until it returns io.EOF, at
+// most limit parts are in flight, limit <= 0 means no limit. next is
+// called sequentially and only when a part may be sent right away, so at
+// most limit parts are held in memory. done is called serialized after
+// each uploaded part with the part number and size. The first error
+// cancels outstanding parts. Returned etags are indexed by part number,
+// whatever order parts finish in.
+func uploadConcurrently(ctx context.Context, limit, blkSize int,
+	next func(n, blkSize int) ([]byte, error),
+	upload func(ctx context.Context, n int, part []byte) (string, error),
+	done func(n, size int)) ([]string, error) {
+	var usema chan struct{}
+	if limit > 0 {
+		usema = make(chan struct{}, limit)
+	}
+	// only the first error matters
+	errChan := make(chan error, 1)
+	// cancel outstanding parts once one of them fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	fail := func(err error) {
+		select {
+		case errChan <- err:
+		default:
+		}
+		cancel()
+	}
+	var (
+		wg    sync.WaitGroup
+		em    sync.Mutex
+		etags []string
+	)
+	for i := 0; ctx.Err() == nil; i++ {
+		if usema != nil {
+			select {
+			case usema <- struct{}{}:
+			case <-ctx.Done():
+				continue
+			}
+		}
+		part, err := next(i, blkSize)
+		if err != nil {
+			if usema != nil {
+				<-usema
+			}
+			if err != io.EOF {
+				fail(err)
+			}
+			break
+		}
+		wg.Add(1)
+		go func(j int, part []byte) {
+			defer func() {
+				wg.Done()
+				if usema != nil {
+					<-usema
+				}
+			}()
+			etag, err := upload(ctx, j, part)
+			if err != nil {
+				fail(err)
+				return
+			}
+			em.Lock()
+			defer em.Unlock()
+			for len(etags) <= j {
+				etags = append(etags, "")
+			}
+			etags[j] = etag
+			if done != nil {
+				done(j, len(part))
+			}
+		}(i, part)
+	}
+	wg.Wait()
+	select {
+	case err := <-errChan:
+		return nil, err
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return etags, nil
+}
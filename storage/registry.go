@@ -0,0 +1,107 @@
+package rrstorage
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Build a storage from a parsed dsn
+type Factory func(u *url.URL) (StorageWrapper, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Make NewStorage build dsns of scheme with f, registering a scheme twice
+// panics
+func Register(scheme string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if f == nil {
+		panic("rrstorage: Register factory is nil")
+	}
+	if _, dup := factories[scheme]; dup {
+		panic("rrstorage: Register called twice for scheme " + scheme)
+	}
+	factories[scheme] = f
+}
+
+// Create a storage from dsn, the scheme picks the backend:
+//
+//	memory://
+//	local:///abs/dir or local://rel/dir
+//	ufile://bucket?public_key=&private_key=&concurrency=
+//	s3://bucket?region=&access_key=&secret_key=&endpoint=&path_style=true
+//
+// Missing ufile keys are read from UFILE_PUBLIC_KEY and UFILE_PRIVATE_KEY,
+// missing s3 ones from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_REGION.
+func NewStorage(dsn string) (StorageWrapper, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage dsn, %s", err)
+	}
+	factoriesMu.RLock()
+	f, ok := factories[u.Scheme]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage scheme %q", u.Scheme)
+	}
+	return f(u)
+}
+
+// query value of key, env var when it is empty
+func param(u *url.URL, key, env string) string {
+	if v := u.Query().Get(key); v != "" {
+		return v
+	}
+	return os.Getenv(env)
+}
+
+func init() {
+	Register("memory", func(u *url.URL) (StorageWrapper, error) {
+		return CreateMemoryStorage(), nil
+	})
+	Register("local", func(u *url.URL) (StorageWrapper, error) {
+		dir := u.Host + u.Path
+		if dir == "" {
+			return nil, fmt.Errorf("local storage dsn %s has no dir", u)
+		}
+		return CreateLocalDiskStorage(dir), nil
+	})
+	Register("ufile", func(u *url.URL) (StorageWrapper, error) {
+		if u.Host == "" {
+			return nil, fmt.Errorf("ufile storage dsn has no bucket")
+		}
+		ucl := 0
+		if v := u.Query().Get("concurrency"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid concurrency %q", v)
+			}
+			ucl = n
+		}
+		return CreateUfileStorage(param(u, "public_key", "UFILE_PUBLIC_KEY"),
+			param(u, "private_key", "UFILE_PRIVATE_KEY"), u.Host, ucl), nil
+	})
+	Register("s3", func(u *url.URL) (StorageWrapper, error) {
+		if u.Host == "" {
+			return nil, fmt.Errorf("s3 storage dsn has no bucket")
+		}
+		s := CreateS3Storage(param(u, "access_key", "AWS_ACCESS_KEY_ID"),
+			param(u, "secret_key", "AWS_SECRET_ACCESS_KEY"), param(u, "region", "AWS_REGION"), u.Host)
+		q := u.Query()
+		s.Endpoint = q.Get("endpoint")
+		if v := q.Get("path_style"); v != "" {
+			ps, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path_style %q", v)
+			}
+			s.PathStyle = ps
+		}
+		return s, nil
+	})
+}
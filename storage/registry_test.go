@@ -0,0 +1,81 @@
+package rrstorage
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewStorage(t *testing.T) {
+	s, err := NewStorage("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(*MemoryStorage); !ok {
+		t.Fatalf("memory:// gave %T", s)
+	}
+
+	dir, err := ioutil.TempDir("", "rrstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err = NewStorage("local://" + filepath.ToSlash(dir) + "/root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save([]byte("hello"), "a/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "root", "a", "b.txt")); err != nil || string(b) != "hello" {
+		t.Fatalf("local file = %q, %v", b, err)
+	}
+
+	os.Setenv("UFILE_PRIVATE_KEY", "pri")
+	defer os.Unsetenv("UFILE_PRIVATE_KEY")
+	s, err = NewStorage("ufile://bucket?public_key=pub&concurrency=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := s.(*UfileStorage)
+	if us.BucketName != "bucket" || us.PublicKey != "pub" || us.PrivateKey != "pri" || us.MaxConcurrency != 2 {
+		t.Fatalf("unexpected ufile storage %+v", us)
+	}
+
+	s, err = NewStorage("s3://bucket?region=us-west-2&access_key=ak&secret_key=sk&endpoint=minio.local:9000&path_style=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := s.(*S3Storage)
+	if ss.BucketName != "bucket" || ss.Region != "us-west-2" || ss.Endpoint != "minio.local:9000" || !ss.PathStyle {
+		t.Fatalf("unexpected s3 storage %+v", ss)
+	}
+
+	for _, dsn := range []string{"ftp://host", "local://", "ufile://", "ufile://b?concurrency=x", "s3://b?path_style=maybe", "%zz"} {
+		if _, err := NewStorage(dsn); err == nil {
+			t.Errorf("NewStorage(%q) should fail", dsn)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("test", func(u *url.URL) (StorageWrapper, error) {
+		return CreateMemoryStorage(), nil
+	})
+	defer func() {
+		factoriesMu.Lock()
+		delete(factories, "test")
+		factoriesMu.Unlock()
+	}()
+	if _, err := NewStorage("test://x"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic registering a scheme twice")
+		}
+	}()
+	Register("test", func(u *url.URL) (StorageWrapper, error) { return nil, nil })
+}
@@ -0,0 +1,48 @@
+package rrstorage
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// send the request built by newReq, connection errors and 5xx responses
+// are retried up to retries times with exponential backoff and jitter,
+// 4xx responses are returned immediately. The response body is read and
+// closed, its content is returned along with the response.
+func doRetry(ctx context.Context, client *http.Client, retries int, backoff time.Duration,
+	newReq func() (*http.Request, error)) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := client.Do(req)
+		var body []byte
+		if err == nil {
+			body, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err == nil && resp.StatusCode < 500 {
+				return resp, body, nil
+			}
+		}
+		if attempt >= retries || ctx.Err() != nil {
+			if err != nil {
+				return nil, nil, err
+			}
+			return resp, body, nil
+		}
+		// backoff * 2^attempt, randomized into [d/2, d)
+		d := backoff << uint(attempt)
+		if d > 0 {
+			d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+		}
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
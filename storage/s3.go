@@ -0,0 +1,428 @@
+package rrstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Amazon S3 storage, requests are signed with AWS signature version 4
+type S3Storage struct {
+	AccessKey  string
+	SecretKey  string
+	Region     string
+	BucketName string
+
+	Endpoint       string // service host, s3.<region>.amazonaws.com when empty
+	PathStyle      bool   // put the bucket in the path rather than the host, as minio expects
+	Scheme         string // "http" or "https", empty means "https"
+	MaxPutSize     int    // larger content is uploaded in parts, MAX_PUT_SIZE when zero
+	PartSize       int    // size of parts but the last one, DEFAULT_S3_PART_SIZE when zero
+	MaxConcurrency int    // max in-flight part uploads, <= 0 means no limit
+
+	HTTPClient *http.Client // client for all requests, nil means a shared default client
+
+	// same as UfileStorage
+	MaxRetries   int
+	RetryBackoff time.Duration
+}
+
+const (
+	DEFAULT_S3_PART_SIZE = 8 * (1 << 20) // s3 requires at least 5M
+)
+
+// Create a S3Storage instance
+func CreateS3Storage(accessKey, secretKey, region, bucket string) *S3Storage {
+	s := &S3Storage{
+		AccessKey:      accessKey,
+		SecretKey:      secretKey,
+		Region:         region,
+		BucketName:     bucket,
+		Scheme:         "https",
+		MaxPutSize:     MAX_PUT_SIZE,
+		PartSize:       DEFAULT_S3_PART_SIZE,
+		MaxConcurrency: DEFAULT_MAX_CONCURRENCY,
+		MaxRetries:     DEFAULT_MAX_RETRIES,
+		RetryBackoff:   DEFAULT_RETRY_BACKOFF,
+	}
+	return s
+}
+
+func (s *S3Storage) client() *http.Client {
+	if s.HTTPClient != nil {
+		return s.HTTPClient
+	}
+	return defaultClient
+}
+
+func (s *S3Storage) maxPutSize() int {
+	if s.MaxPutSize > 0 {
+		return s.MaxPutSize
+	}
+	return MAX_PUT_SIZE
+}
+
+func (s *S3Storage) partSize() int {
+	if s.PartSize > 0 {
+		return s.PartSize
+	}
+	return DEFAULT_S3_PART_SIZE
+}
+
+// scheme://bucket.endpoint/key?query, or scheme://endpoint/bucket/key?query
+// with PathStyle
+func (s *S3Storage) requestURL(key string, query url.Values) string {
+	scheme := s.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "s3." + s.Region + ".amazonaws.com"
+	}
+	u := scheme + "://" + s.BucketName + "." + endpoint + "/" + awsEscape(key, false)
+	if s.PathStyle {
+		u = scheme + "://" + endpoint + "/" + awsEscape(s.BucketName, true) + "/" + awsEscape(key, false)
+	}
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+	return u
+}
+
+// uri encode as aws expects, only unreserved characters are kept, '/'
+// too unless encodeSlash
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// query sorted by key, every key is followed by '=' even without value
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign req with aws signature version 4. Host and every header already
+// set on req are signed, x-amz-date and x-amz-content-sha256 are added.
+func (s *S3Storage) signV4(req *http.Request, payloadHash string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders string
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := req.Method + "\n" +
+		path + "\n" +
+		req.URL.RawQuery + "\n" +
+		canonicalHeaders + "\n" +
+		signedHeaders + "\n" +
+		payloadHash
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		",SignedHeaders="+signedHeaders+",Signature="+signature)
+}
+
+// send a signed request, up to retries times on connection errors and
+// 5xx responses. The response body is read and closed.
+func (s *S3Storage) do(ctx context.Context, method, key string, query url.Values,
+	header http.Header, body []byte, retries int) (*http.Response, []byte, error) {
+	u := s.requestURL(key, query)
+	payloadHash := hexSHA256(body)
+	return doRetry(ctx, s.client(), retries, s.RetryBackoff, func() (*http.Request, error) {
+		var rb io.Reader
+		if body != nil {
+			rb = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u, rb)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		s.signV4(req, payloadHash, now())
+		return req, nil
+	})
+}
+
+func (s *S3Storage) Save(content []byte, filename string) error {
+	return s.SaveContext(context.Background(), content, filename)
+}
+
+// Save binary, in-flight requests are cancelled once ctx is done
+func (s *S3Storage) SaveContext(ctx context.Context, content []byte, filename string) error {
+	size := len(content)
+	if size > s.maxPutSize() {
+		return s.multipartUpload(ctx, filename, func(n, blkSize int) ([]byte, error) {
+			if n*blkSize >= size {
+				return nil, io.EOF
+			}
+			end := (n + 1) * blkSize
+			if end > size {
+				end = size
+			}
+			return content[n*blkSize : end], nil
+		})
+	}
+	header := http.Header{}
+	header.Set("Content-Type", guessContentType(filename))
+	resp, body, err := s.do(ctx, "PUT", filename, nil, header, content, s.MaxRetries)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("put object failed, %w", statusError("put", resp, body))
+	}
+	return nil
+}
+
+type s3InitResponse struct {
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+type s3CompleteRequest struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []s3Part `xml:"Part"`
+}
+
+type s3CompleteResponse struct {
+	XMLName xml.Name // CompleteMultipartUploadResult, CopyObjectResult or Error
+	Key     string
+	ETag    string
+	Code    string
+	Message string
+}
+
+func (s *S3Storage) multipartUpload(ctx context.Context, filename string, next func(n, blkSize int) ([]byte, error)) error {
+	header := http.Header{}
+	header.Set("Content-Type", guessContentType(filename))
+	resp, body, err := s.do(ctx, "POST", filename, url.Values{"uploads": {""}}, header, nil, 0)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("initiate multipart upload failed, %w", statusError("initiateMultipartUpload", resp, body))
+	}
+	var initRes s3InitResponse
+	if err := xml.Unmarshal(body, &initRes); err != nil {
+		return err
+	}
+	if err := s.uploadParts(ctx, filename, initRes.UploadId, next); err != nil {
+		// drop uploaded parts, report the original error, the context
+		// of a failed upload may be done already
+		s.do(context.Background(), "DELETE", filename, url.Values{"uploadId": {initRes.UploadId}}, nil, nil, 0)
+		return err
+	}
+	return nil
+}
+
+// upload all parts then complete the upload
+func (s *S3Storage) uploadParts(ctx context.Context, filename, uploadId string, next func(n, blkSize int) ([]byte, error)) error {
+	etags, err := uploadConcurrently(ctx, s.MaxConcurrency, s.partSize(), next,
+		func(ctx context.Context, n int, part []byte) (string, error) {
+			// s3 part numbers start from 1
+			query := url.Values{
+				"partNumber": {strconv.Itoa(n + 1)},
+				"uploadId":   {uploadId},
+			}
+			resp, body, err := s.do(ctx, "PUT", filename, query, nil, part, s.MaxRetries)
+			if err != nil {
+				return "", err
+			}
+			if resp.StatusCode != 200 {
+				return "", fmt.Errorf("upload part failed, %w", statusError("uploadPart", resp, body))
+			}
+			return resp.Header.Get("ETag"), nil
+		}, nil)
+	if err != nil {
+		return err
+	}
+	complete := s3CompleteRequest{}
+	for i, etag := range etags {
+		complete.Parts = append(complete.Parts, s3Part{PartNumber: i + 1, ETag: etag})
+	}
+	cb, err := xml.Marshal(&complete)
+	if err != nil {
+		return err
+	}
+	resp, body, err := s.do(ctx, "POST", filename, url.Values{"uploadId": {uploadId}}, nil, cb, 0)
+	if err != nil {
+		return err
+	}
+	var res s3CompleteResponse
+	if resp.StatusCode == 200 {
+		// errors may come with status 200 too
+		if err := xml.Unmarshal(body, &res); err != nil {
+			return err
+		}
+	}
+	if resp.StatusCode != 200 || res.XMLName.Local == "Error" {
+		return fmt.Errorf("complete multipart upload failed, %w", statusError("completeMultipartUpload", resp, body))
+	}
+	return nil
+}
+
+func (s *S3Storage) Fetch(filename string) ([]byte, error) {
+	return s.FetchContext(context.Background(), filename)
+}
+
+func (s *S3Storage) FetchContext(ctx context.Context, filename string) ([]byte, error) {
+	resp, body, err := s.do(ctx, "GET", filename, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("get object failed, %w", statusError("get", resp, body))
+	}
+	return body, nil
+}
+
+func (s *S3Storage) Delete(filename string) error {
+	return s.DeleteContext(context.Background(), filename)
+}
+
+func (s *S3Storage) DeleteContext(ctx context.Context, filename string) error {
+	resp, body, err := s.do(ctx, "DELETE", filename, nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		return fmt.Errorf("delete object failed, %w", statusError("delete", resp, body))
+	}
+	return nil
+}
+
+// Copy srcKey to dstKey within the bucket, bytes stay on the server
+func (s *S3Storage) Copy(srcKey, dstKey string) error {
+	return s.CopyContext(context.Background(), srcKey, dstKey)
+}
+
+func (s *S3Storage) CopyContext(ctx context.Context, srcKey, dstKey string) error {
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", "/"+s.BucketName+"/"+awsEscape(srcKey, false))
+	resp, body, err := s.do(ctx, "PUT", dstKey, nil, header, nil, s.MaxRetries)
+	if err != nil {
+		return err
+	}
+	var res s3CompleteResponse
+	if resp.StatusCode == 200 {
+		// errors may come with status 200 too
+		if err := xml.Unmarshal(body, &res); err != nil {
+			return err
+		}
+	}
+	if resp.StatusCode != 200 || res.XMLName.Local == "Error" {
+		return fmt.Errorf("copy object failed, %w", statusError("copy", resp, body))
+	}
+	return nil
+}
+
+// Copy src to dst then delete src, dst is kept when src can't be deleted
+func (s *S3Storage) Move(src, dst string) error {
+	return s.MoveContext(context.Background(), src, dst)
+}
+
+func (s *S3Storage) MoveContext(ctx context.Context, src, dst string) error {
+	if err := s.CopyContext(ctx, src, dst); err != nil {
+		return err
+	}
+	if err := s.DeleteContext(ctx, src); err != nil {
+		return fmt.Errorf("move object failed, %s copied to %s but not deleted, %w", src, dst, err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Exists(filename string) (bool, error) {
+	return s.ExistsContext(context.Background(), filename)
+}
+
+// Report whether filename exists, the request is cancelled once ctx is done
+func (s *S3Storage) ExistsContext(ctx context.Context, filename string) (bool, error) {
+	resp, _, err := s.do(ctx, "HEAD", filename, nil, nil, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	switch resp.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	}
+	return false, fmt.Errorf("head object failed, %w", statusError("head", resp, nil))
+}
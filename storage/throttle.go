@@ -0,0 +1,51 @@
+package rrstorage
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// paces reads of all readers sharing it to a rate in bytes per second
+type rateLimiter struct {
+	mu   sync.Mutex
+	next time.Time // when the bytes read so far are due
+}
+
+// wait until n more bytes fit in rate
+func (l *rateLimiter) wait(n, rate int) {
+	l.mu.Lock()
+	t := time.Now()
+	if l.next.Before(t) {
+		l.next = t
+	}
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(rate))
+	d := l.next.Sub(t)
+	l.mu.Unlock()
+	time.Sleep(d)
+}
+
+type throttledReader struct {
+	r    io.Reader
+	lim  *rateLimiter
+	rate int
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// small reads keep the pace smooth
+	max := t.rate / 10
+	if max < 1 {
+		max = 1
+	}
+	if max > 32<<10 {
+		max = 32 << 10
+	}
+	if len(p) > max {
+		p = p[:max]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.lim.wait(n, t.rate)
+	}
+	return n, err
+}
@@ -2,18 +2,27 @@ package rrstorage
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"github.com/cheggaaa/pb"
+	"github.com/songtianyi/rrframework/logs"
 	"crypto/hmac"
+	"crypto/md5"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
-	"github.com/songtianyi/rrframework/logs"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type UfileStorage struct {
@@ -21,7 +30,44 @@ type UfileStorage struct {
 	PrivateKey string
 	BucketName string
 
-	usema chan struct{}	// uploading concurrency limit
+	MaxConcurrency int    // max in-flight part uploads or range gets, <= 0 means no limit
+	Scheme         string // "http" or "https", empty means "http"
+
+	// defaults are used for zero values
+	Endpoint      string // domain suffix after bucket name, SUFFIX by default
+	MaxPutSize    int    // larger content is uploaded in parts, MAX_PUT_SIZE by default
+	ExpireSeconds int    // lifetime of signed urls, EXPIRE by default
+
+	// Content-Type of uploads, guessed from the filename extension when empty
+	ContentType string
+	// skip the Content-MD5 integrity check of uploads, saves hashing them
+	NoContentMD5 bool
+	// gzip content of Save and its []byte variants, sent with
+	// Content-Encoding gzip, Fetch decompresses such files. SaveStream
+	// and checkpointed uploads send bytes as they are.
+	Gzip bool
+	// access of uploaded files, ACL_PUBLIC_READ or ACL_PRIVATE, the bucket
+	// default when empty
+	ACL string
+
+	HTTPClient *http.Client  // client for all requests, nil means a shared default client
+	Timeout    time.Duration // limit of every single request, zero means no limit
+
+	// uploads failing with connection errors or 5xx responses are retried
+	// MaxRetries times, waiting RetryBackoff, 2*RetryBackoff, ... in between
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// upload rate in bytes per second shared by concurrent requests, zero
+	// means unlimited
+	RateLimit int
+	limiter   *rateLimiter
+
+	// called by Save as a single put writes its body and after each
+	// uploaded part, with the bytes uploaded so far and the total size,
+	// -1 when unknown. Calls are serialized, a retried put starts over
+	// from zero.
+	ProgressFunc func(uploaded, total int64)
 }
 
 const (
@@ -30,23 +76,223 @@ const (
 	MAX_PUT_SIZE = 50 * (1 << 20)
 	MAX_GET_SIZE = 50 * (1 << 20)
 	PARTIAL_SIZE = 4 * (1 << 20)
+
+	ACL_PUBLIC_READ = "public-read"
+	ACL_PRIVATE     = "private"
+
+	DEFAULT_MAX_CONCURRENCY = 4
+	DEFAULT_MAX_RETRIES     = 3
+	DEFAULT_RETRY_BACKOFF   = 500 * time.Millisecond
 )
 
-func CreateUfileStorage(pub, pri, bun string, ucl int) StorageWrapper {
+// shared by UfileStorage instances without their own HTTPClient,
+// bounds connecting and waiting for response headers but not the
+// whole request, large uploads may take long
+var defaultClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 60 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	},
+}
+
+// Create a UfileStorage instance, ucl limits concurrent part uploads,
+// DEFAULT_MAX_CONCURRENCY is used when ucl <= 0
+func CreateUfileStorage(pub, pri, bun string, ucl int) *UfileStorage {
+	if ucl <= 0 {
+		ucl = DEFAULT_MAX_CONCURRENCY
+	}
 	s := &UfileStorage{
-		PublicKey:  pub,
-		PrivateKey: pri,
-		BucketName: bun,
-		usema: make(chan struct{}, ucl),
+		PublicKey:      pub,
+		PrivateKey:     pri,
+		BucketName:     bun,
+		MaxConcurrency: ucl,
+		Scheme:         "https",
+		Endpoint:       SUFFIX,
+		MaxPutSize:     MAX_PUT_SIZE,
+		ExpireSeconds:  EXPIRE,
+		MaxRetries:     DEFAULT_MAX_RETRIES,
+		RetryBackoff:   DEFAULT_RETRY_BACKOFF,
+		limiter:        &rateLimiter{},
 	}
 	return s
 }
 
-func (s *UfileStorage) signheader(method, ctype, bucket, filename string) string {
+// Set max in-flight part uploads, n <= 0 means no limit
+func (s *UfileStorage) SetMaxConcurrency(n int) {
+	s.MaxConcurrency = n
+}
+
+func (s *UfileStorage) client() *http.Client {
+	c := defaultClient
+	if s.HTTPClient != nil {
+		c = s.HTTPClient
+	}
+	if s.Timeout > 0 {
+		// shallow copy keeps the transport and its connection pool
+		tc := *c
+		tc.Timeout = s.Timeout
+		return &tc
+	}
+	return c
+}
+
+// reader of content paced to RateLimit
+func (s *UfileStorage) uploadBody(content []byte) io.Reader {
+	if s.RateLimit <= 0 {
+		return bytes.NewReader(content)
+	}
+	lim := s.limiter
+	if lim == nil {
+		// not made by CreateUfileStorage, pace this request alone
+		lim = &rateLimiter{}
+	}
+	return &throttledReader{r: bytes.NewReader(content), lim: lim, rate: s.RateLimit}
+}
+
+// reports bytes read so far to progress, mu is shared by the readers of
+// all attempts of one request
+type progressReader struct {
+	r        io.Reader
+	mu       *sync.Mutex
+	read     int64
+	total    int64
+	progress func(uploaded, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.mu.Lock()
+		p.read += int64(n)
+		p.progress(p.read, p.total)
+		p.mu.Unlock()
+	}
+	return n, err
+}
+
+func (s *UfileStorage) endpoint() string {
+	if s.Endpoint != "" {
+		return s.Endpoint
+	}
+	return SUFFIX
+}
+
+func (s *UfileStorage) maxPutSize() int {
+	if s.MaxPutSize > 0 {
+		return s.MaxPutSize
+	}
+	return MAX_PUT_SIZE
+}
+
+func (s *UfileStorage) expire() time.Duration {
+	if s.ExpireSeconds > 0 {
+		return time.Duration(s.ExpireSeconds) * time.Second
+	}
+	return EXPIRE * time.Second
+}
+
+func (s *UfileStorage) contentType(filename string) string {
+	if s.ContentType != "" {
+		return s.ContentType
+	}
+	return guessContentType(filename)
+}
+
+// mime type by extension of filename, application/octet-stream when unknown
+func guessContentType(filename string) string {
+	if ctype := mime.TypeByExtension(filepath.Ext(filename)); ctype != "" {
+		return ctype
+	}
+	return "application/octet-stream"
+}
+
+// scheme://bucket.suffix/key?query
+func (s *UfileStorage) requestURL(bucket, key, query string) string {
+	scheme := s.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	u := scheme + "://" + bucket + s.endpoint() + "/" + escapeKey(key)
+	if query != "" {
+		u += "?" + query
+	}
+	return u
+}
+
+// canonical prefix of user metadata headers, they are not part of the
+// signature, only X-UCloud-* headers would be
+const metaPrefix = "X-Ufile-Meta-"
+
+func addMeta(h http.Header, meta map[string]string) {
+	for k, v := range meta {
+		h.Set(metaPrefix+k, v)
+	}
+}
+
+// header carrying ACL, unsigned like the metadata ones
+const aclHeader = "X-Ufile-Acl"
+
+func (s *UfileStorage) addACL(h http.Header) {
+	if s.ACL != "" {
+		h.Set(aclHeader, s.ACL)
+	}
+}
+
+// escape each segment of key for use in an url path, '/' is kept,
+// signatures are still computed over the raw key
+func escapeKey(key string) string {
+	segs := strings.Split(key, "/")
+	for i := range segs {
+		segs[i] = url.PathEscape(segs[i])
+	}
+	return strings.Join(segs, "/")
+}
+
+// base64 encoded md5 of b, value of Content-MD5 header
+func contentMD5(b []byte) string {
+	sum := md5.Sum(b)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Content-MD5 for an upload of b, empty when disabled
+func (s *UfileStorage) uploadMD5(b []byte) string {
+	if s.NoContentMD5 {
+		return ""
+	}
+	return contentMD5(b)
+}
+
+func (s *UfileStorage) signheader(method, cmd5, ctype, bucket, filename string) string {
+	return s.sign(method, cmd5, ctype, "", bucket, filename)
+}
+
+// date is the Date header for signed requests, or the Expires
+// timestamp for query signed urls
+func (s *UfileStorage) sign(method, cmd5, ctype, date, bucket, filename string) string {
 	data := method + "\n"
-	data += "\n"         //Content-MD5 empty
+	data += cmd5 + "\n"  //Content-MD5
 	data += ctype + "\n" //Content-Type
-	data += "\n"         //Date empty
+	data += date + "\n"  //Date or Expires
 	data += "/" + bucket + "/" + filename
 
 	h := hmac.New(sha1.New, []byte(s.PrivateKey))
@@ -54,6 +300,44 @@ func (s *UfileStorage) signheader(method, ctype, bucket, filename string) string
 	return base64.StdEncoding.EncodeToString(h.Sum(nil))
 }
 
+// replaced in tests
+var now = time.Now
+
+// Url for downloading filename without credentials, valid for expire,
+// ExpireSeconds are used when expire is zero
+func (s *UfileStorage) SignedDownloadURL(filename string, expire time.Duration) (string, error) {
+	if expire < 0 {
+		return "", fmt.Errorf("invalid expire %s", expire)
+	}
+	if expire == 0 {
+		expire = s.expire()
+	}
+	expires := strconv.FormatInt(now().Add(expire).Unix(), 10)
+	sign := s.sign("GET", "", "", expires, s.BucketName, filename)
+	query := "UCloudPublicKey=" + url.QueryEscape(s.PublicKey)
+	query += "&Expires=" + expires
+	query += "&Signature=" + url.QueryEscape(sign)
+	return s.requestURL(s.BucketName, filename, query), nil
+}
+
+// Url a client can PUT filename to without credentials, valid for expire,
+// ExpireSeconds are used when expire is zero. The request must carry
+// Content-Type contentType and no Content-MD5, both are signed.
+func (s *UfileStorage) PresignPutURL(filename string, expire time.Duration, contentType string) (string, error) {
+	if expire < 0 {
+		return "", fmt.Errorf("invalid expire %s", expire)
+	}
+	if expire == 0 {
+		expire = s.expire()
+	}
+	expires := strconv.FormatInt(now().Add(expire).Unix(), 10)
+	sign := s.sign("PUT", "", contentType, expires, s.BucketName, filename)
+	query := "UCloudPublicKey=" + url.QueryEscape(s.PublicKey)
+	query += "&Expires=" + expires
+	query += "&Signature=" + url.QueryEscape(sign)
+	return s.requestURL(s.BucketName, filename, query), nil
+}
+
 type initResponse struct {
 	UploadId string
 	BlkSize  int
@@ -61,16 +345,24 @@ type initResponse struct {
 	Key      string
 }
 
-func (s *UfileStorage) initiateMultipartUpload(filename string) (*initResponse, error) {
-	sign := s.signheader("POST", "application/octet-stream", s.BucketName, filename)
+func (s *UfileStorage) initiateMultipartUpload(ctx context.Context, filename string, header http.Header) (*initResponse, error) {
+	// the type given here is the one the finished file gets
+	ctype := s.contentType(filename)
+	sign := s.signheader("POST", "", ctype, s.BucketName, filename)
 
 	auth := "UCloud" + " " + s.PublicKey + ":" + sign
-	client := &http.Client{}
-	url := "http://" + s.BucketName + SUFFIX + "/" + filename + "?uploads"
-	req, err := http.NewRequest("POST", url, nil)
-
+	client := s.client()
+	url := s.requestURL(s.BucketName, filename, "uploads")
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Add("Authorization", auth)
-	req.Header.Add("Content-Type", "application/octet-stream")
+	req.Header.Add("Content-Type", ctype)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.addACL(req.Header)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -82,7 +374,7 @@ func (s *UfileStorage) initiateMultipartUpload(filename string) (*initResponse,
 		return nil, err
 	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("initiateMultipartUpload failed, %s", string(body))
+		return nil, fmt.Errorf("initiateMultipartUpload failed, %w", statusError("initiateMultipartUpload", resp, body))
 	}
 	var res initResponse
 	if err := json.Unmarshal(body, &res); err != nil {
@@ -95,51 +387,87 @@ type uploadResponse struct {
 	PartNumber int
 }
 
-func (s *UfileStorage) uploadPart(content []byte, info *initResponse, partNum int) (*uploadResponse, string, error) {
-	sign := s.signheader("PUT", "application/octet-stream", info.Bucket, info.Key)
+func (s *UfileStorage) uploadPart(ctx context.Context, content []byte, info *initResponse, partNum int) (*uploadResponse, string, error) {
+	cmd5 := s.uploadMD5(content)
+	sign := s.signheader("PUT", cmd5, "application/octet-stream", info.Bucket, info.Key)
+
+	auth := "UCloud" + " " + s.PublicKey + ":" + sign
+	url := s.requestURL(info.Bucket, info.Key, "uploadId="+info.UploadId+"&partNumber="+strconv.Itoa(partNum))
+	resp, body, err := doRetry(ctx, s.client(), s.MaxRetries, s.RetryBackoff, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "PUT", url, s.uploadBody(content))
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = int64(len(content))
+		req.Header.Add("Authorization", auth)
+		if cmd5 != "" {
+			req.Header.Add("Content-MD5", cmd5)
+		}
+		req.Header.Add("Content-Type", "application/octet-stream")
+		// the last part may be shorter than BlkSize
+		req.Header.Add("Content-Length", strconv.Itoa(len(content)))
+		return req, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != 200 {
+		return nil, "", fmt.Errorf("uploadPart failed, %w", statusError("uploadPart", resp, body))
+	}
+	var res uploadResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, "", err
+	}
+	return &res, resp.Header.Get("ETag"), nil
+}
+
+// give up an upload, parts uploaded are dropped by the server
+func (s *UfileStorage) abortMultipartUpload(info *initResponse) error {
+	sign := s.signheader("DELETE", "", "", info.Bucket, info.Key)
 
 	auth := "UCloud" + " " + s.PublicKey + ":" + sign
-	client := &http.Client{}
-	url := "http://" + info.Bucket + SUFFIX + "/" + info.Key + "?uploadId=" + info.UploadId + "&partNumber=" + strconv.Itoa(partNum)
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(content))
+	client := s.client()
+	url := s.requestURL(info.Bucket, info.Key, "uploadId="+info.UploadId)
+	// the context of a failed upload may be done already
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
 
 	req.Header.Add("Authorization", auth)
-	req.Header.Add("Content-Type", "application/octet-stream")
-	req.Header.Add("Content-Length", strconv.Itoa(info.BlkSize))
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, "", err
+		return err
 	}
 	body, err := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
 	if err != nil {
-		return nil, "", err
-	}
-	if resp.StatusCode != 200 {
-		return nil, "", fmt.Errorf("uploadPart failed, %s", string(body))
+		return err
 	}
-	var res uploadResponse
-	if err := json.Unmarshal(body, &res); err != nil {
-		return nil, "", err
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		return fmt.Errorf("abortMultipartUpload failed, %w", statusError("abortMultipartUpload", resp, body))
 	}
-	return &res, resp.Header.Get("ETag"), nil
+	return nil
 }
 
 type finishResponse struct {
 	Bucket   string
 	Key      string
-	FileSize int
+	FileSize int64
+	ETag     string
 }
 
-func (s *UfileStorage) finishMultipartUpload(info *initResponse, etags string) (*finishResponse, error) {
-	sign := s.signheader("POST", "text/plain", info.Bucket, info.Key)
+func (s *UfileStorage) finishMultipartUpload(ctx context.Context, info *initResponse, etags string) (*finishResponse, error) {
+	sign := s.signheader("POST", "", "text/plain", info.Bucket, info.Key)
 
 	auth := "UCloud" + " " + s.PublicKey + ":" + sign
-	client := &http.Client{}
-	url := "http://" + info.Bucket + SUFFIX + "/" + info.Key + "?uploadId=" + info.UploadId + "&newKey=" + info.Key
-	req, err := http.NewRequest("POST", url, strings.NewReader(etags))
-
+	client := s.client()
+	url := s.requestURL(info.Bucket, info.Key, "uploadId="+info.UploadId+"&newKey="+url.QueryEscape(info.Key))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(etags))
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Add("Authorization", auth)
 	req.Header.Add("Content-Length", strconv.Itoa(len(etags)))
 	req.Header.Add("Content-Type", "text/plain")
@@ -153,103 +481,471 @@ func (s *UfileStorage) finishMultipartUpload(info *initResponse, etags string) (
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("finishMultipartUpload failed, %s", string(body))
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		return nil, fmt.Errorf("finishMultipartUpload failed, %w", statusError("finishMultipartUpload", resp, body))
 	}
-	var res finishResponse
-	if err := json.Unmarshal(body, &res); err != nil {
-		return nil, err
+	// finished without a body, the caller fills in FileSize
+	res := finishResponse{
+		Bucket: info.Bucket,
+		Key:    info.Key,
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &res); err != nil {
+			return nil, err
+		}
+	}
+	if res.ETag == "" {
+		res.ETag = strings.Trim(resp.Header.Get("ETag"), `"`)
 	}
 	return &res, nil
 }
 
-func (s *UfileStorage) put(content []byte, filename string) error {
+func (s *UfileStorage) put(ctx context.Context, content []byte, filename string, header http.Header) (*ObjectInfo, error) {
 	// sign
-	sign := s.signheader("PUT", "application/octet-stream", s.BucketName, filename)
+	cmd5 := s.uploadMD5(content)
+	ctype := s.contentType(filename)
+	sign := s.signheader("PUT", cmd5, ctype, s.BucketName, filename)
 	auth := "UCloud" + " " + s.PublicKey + ":" + sign
-	client := &http.Client{}
-	url := "http://" + s.BucketName + SUFFIX + "/" + filename
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(content))
-
-	req.Header.Add("Authorization", auth)
-	req.Header.Add("Content-Type", "application/octet-stream")
-	req.Header.Add("Content-Length", strconv.Itoa(len(content)))
-
-	resp, err := client.Do(req)
+	url := s.requestURL(s.BucketName, filename, "")
+	var pmu sync.Mutex
+	resp, body, err := doRetry(ctx, s.client(), s.MaxRetries, s.RetryBackoff, func() (*http.Request, error) {
+		rb := s.uploadBody(content)
+		if s.ProgressFunc != nil {
+			// a new body for every attempt, counting from zero
+			rb = &progressReader{r: rb, mu: &pmu, total: int64(len(content)), progress: s.ProgressFunc}
+		}
+		req, err := http.NewRequestWithContext(ctx, "PUT", url, rb)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = int64(len(content))
+		req.Header.Add("Authorization", auth)
+		if cmd5 != "" {
+			req.Header.Add("Content-MD5", cmd5)
+		}
+		req.Header.Add("Content-Type", ctype)
+		req.Header.Add("Content-Length", strconv.Itoa(len(content)))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		s.addACL(req.Header)
+		return req, nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if resp.StatusCode != 200 {
-		body, err := ioutil.ReadAll(resp.Body)
-		defer resp.Body.Close()
-		if err != nil {
-			return err
-		}
-		return fmt.Errorf("put file failed, %s", string(body))
+		return nil, fmt.Errorf("put file failed, %w", statusError("put", resp, body))
 	}
-	return nil
+	return &ObjectInfo{
+		Key:  filename,
+		Size: int64(len(content)),
+		ETag: strings.Trim(resp.Header.Get("ETag"), `"`),
+	}, nil
 }
 
 func (s *UfileStorage) Save(content []byte, filename string) error {
+	return s.SaveContext(context.Background(), content, filename)
+}
 
+// Save binary, in-flight requests are cancelled once ctx is done
+func (s *UfileStorage) SaveContext(ctx context.Context, content []byte, filename string) error {
+	_, err := s.save(ctx, content, filename, nil)
+	return err
+}
+
+// Save binary, returns key, size and ETag of the stored object
+func (s *UfileStorage) SaveWithInfo(content []byte, filename string) (*ObjectInfo, error) {
+	return s.save(context.Background(), content, filename, nil)
+}
+
+// Save binary with user metadata, sent as X-Ufile-Meta-<k> headers,
+// read it back with GetMeta
+func (s *UfileStorage) SaveWithMeta(content []byte, filename string, meta map[string]string) error {
+	_, err := s.save(context.Background(), content, filename, meta)
+	return err
+}
+
+// Save binary with acl instead of ACL, so public and private files can
+// share a bucket
+func (s *UfileStorage) SaveWithACL(content []byte, filename string, acl string) error {
+	c := *s
+	c.ACL = acl
+	return c.Save(content, filename)
+}
+
+func (s *UfileStorage) save(ctx context.Context, content []byte, filename string, meta map[string]string) (*ObjectInfo, error) {
+	header := http.Header{}
+	addMeta(header, meta)
+	if s.Gzip {
+		var err error
+		if content, err = gzipBytes(content); err != nil {
+			return nil, err
+		}
+		header.Set("Content-Encoding", "gzip")
+	}
+	return s.upload(ctx, content, filename, header)
+}
+
+// put or multipart upload content as it is, with extra request headers
+func (s *UfileStorage) upload(ctx context.Context, content []byte, filename string, header http.Header) (*ObjectInfo, error) {
 	size := len(content)
-	if size > MAX_PUT_SIZE {
-		// > 50M
-		initRes, err := s.initiateMultipartUpload(filename)
+	if size > s.maxPutSize() {
+		// > 50M by default
+		return s.multipartUpload(ctx, filename, int64(size), header, func(n, blkSize int) ([]byte, error) {
+			if n*blkSize >= size {
+				return nil, io.EOF
+			}
+			end := (n + 1) * blkSize
+			if end > size {
+				end = size
+			}
+			return content[n*blkSize : end], nil
+		})
+	}
+	return s.put(ctx, content, filename, header)
+}
+
+// Save size bytes read from r, size < 0 means unknown. Only one block
+// per in-flight part is held in memory, so big files need not be
+// buffered as a whole. A reader ending before size bytes fails with
+// io.ErrUnexpectedEOF. With an unknown size ProgressFunc gets a total
+// of -1 and the progress bar has no part count.
+func (s *UfileStorage) SaveStream(r io.Reader, size int64, filename string) error {
+	return s.SaveStreamContext(context.Background(), r, size, filename)
+}
+
+func (s *UfileStorage) SaveStreamContext(ctx context.Context, r io.Reader, size int64, filename string) error {
+	if size < 0 {
+		// read one chunk to decide between put and multipart
+		first, err := ioutil.ReadAll(io.LimitReader(r, int64(s.maxPutSize())+1))
 		if err != nil {
 			return err
 		}
-		num := size / initRes.BlkSize
-		bar := pb.StartNew(num+1)
-		etags := make([]string, 0)
-		var (
-			wg sync.WaitGroup
-			em sync.Mutex
-		)
-		for i := 0; i < num; i++ {
-			s.usema <- struct{}{}
-			wg.Add(1)
-			go func(j int) {
-				defer func() {
-					wg.Done()
-					<-s.usema
-				}()
-				part := content[j*initRes.BlkSize : (j+1)*initRes.BlkSize]
-				_, etag, err := s.uploadPart(part, initRes, j)
-				if err != nil {
-					logs.Error(err)
-					return
-				}
-				em.Lock()
-				etags = append(etags, etag)
-				bar.Increment()
-				em.Unlock()
-			}(i)
+		if len(first) <= s.maxPutSize() {
+			_, err = s.upload(ctx, first, filename, nil)
+			return err
 		}
-		// TODO capture error
-		wg.Wait()
-		if num*initRes.BlkSize < size {
-			// remaining part
-			part := content[num*initRes.BlkSize:]
-			_, etag, err := s.uploadPart(part, initRes, num)
-			if err != nil {
-				return err
+		r = io.MultiReader(bytes.NewReader(first), r)
+	} else if size <= int64(s.maxPutSize()) {
+		content := make([]byte, size)
+		if _, err := io.ReadFull(r, content); err != nil {
+			return err
+		}
+		_, err := s.upload(ctx, content, filename, nil)
+		return err
+	} else {
+		r = io.LimitReader(r, size)
+	}
+	var read int64
+	_, err := s.multipartUpload(ctx, filename, size, nil, func(n, blkSize int) ([]byte, error) {
+		part := make([]byte, blkSize)
+		rn, err := io.ReadFull(r, part)
+		read += int64(rn)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if size >= 0 && read < size {
+				// do not commit a truncated file
+				return nil, io.ErrUnexpectedEOF
 			}
-			etags = append(etags, etag)
-			bar.Increment()
+			if rn == 0 {
+				return nil, io.EOF
+			}
+			// last part
+			return part[:rn], nil
 		}
-		_, err = s.finishMultipartUpload(initRes, strings.Join(etags, ","))
-		if err != nil {
-			return err
+		return part, err
+	})
+	return err
+}
+
+// Upload parts returned by next until it returns io.EOF, size is only
+// used for reporting progress. next is called sequentially and only when
+// a part may be sent right away, so at most MaxConcurrency parts are held.
+func (s *UfileStorage) multipartUpload(ctx context.Context, filename string, size int64, header http.Header,
+	next func(n, blkSize int) ([]byte, error)) (*ObjectInfo, error) {
+	initRes, err := s.initiateMultipartUpload(ctx, filename, header)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.uploadParts(ctx, initRes, size, next)
+	if err != nil {
+		// drop uploaded parts, report the original error
+		if aerr := s.abortMultipartUpload(initRes); aerr != nil {
+			logs.Error("abort upload %s failed, %s", initRes.UploadId, aerr)
 		}
-		bar.Finish()
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:  res.Key,
+		Size: res.FileSize,
+		ETag: res.ETag,
+	}, nil
+}
 
-	} else {
-		return s.put(content, filename)
+// upload all parts then finish the upload
+func (s *UfileStorage) uploadParts(ctx context.Context, initRes *initResponse, size int64,
+	next func(n, blkSize int) ([]byte, error)) (*finishResponse, error) {
+	blkSize := initRes.BlkSize
+	if blkSize <= 0 {
+		return nil, fmt.Errorf("initiateMultipartUpload failed, invalid BlkSize %d", blkSize)
+	}
+	num := 0
+	if size > 0 {
+		num = int((size + int64(blkSize) - 1) / int64(blkSize))
+	}
+	bar := pb.StartNew(num)
+	defer bar.Finish()
+	var uploaded int64
+	etags, err := uploadConcurrently(ctx, s.MaxConcurrency, blkSize, next,
+		func(ctx context.Context, n int, part []byte) (string, error) {
+			_, etag, err := s.uploadPart(ctx, part, initRes, n)
+			return etag, err
+		},
+		func(n, psize int) {
+			bar.Increment()
+			uploaded += int64(psize)
+			if s.ProgressFunc != nil {
+				s.ProgressFunc(uploaded, size)
+			}
+		})
+	if err != nil {
+		// do not finish an incomplete upload
+		return nil, err
+	}
+	res, err := s.finishMultipartUpload(ctx, initRes, strings.Join(etags, ","))
+	if err != nil {
+		return nil, err
+	}
+	if res.FileSize == 0 {
+		res.FileSize = uploaded
+	}
+	return res, nil
+}
+
+func (s *UfileStorage) Delete(filename string) error {
+	return s.DeleteContext(context.Background(), filename)
+}
+
+func (s *UfileStorage) DeleteContext(ctx context.Context, filename string) error {
+	// sign
+	sign := s.signheader("DELETE", "", "", s.BucketName, filename)
+	auth := "UCloud" + " " + s.PublicKey + ":" + sign
+	client := s.client()
+	url := s.requestURL(s.BucketName, filename, "")
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", auth)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		return fmt.Errorf("delete file failed, %w", statusError("delete", resp, body))
 	}
 	return nil
 }
 
+// As DeleteMany, returns the keys failed to delete in the order given
+func (s *UfileStorage) DeleteBatch(filenames []string) ([]string, error) {
+	errs, err := s.DeleteMany(filenames)
+	if err != nil {
+		return nil, err
+	}
+	var failed []string
+	for _, filename := range filenames {
+		if _, ok := errs[filename]; ok {
+			failed = append(failed, filename)
+		}
+	}
+	return failed, nil
+}
+
+// Delete filenames concurrently, at most MaxConcurrency at a time. The
+// returned map only holds keys failed to delete, error is only returned
+// when nothing could be attempted.
+func (s *UfileStorage) DeleteMany(filenames []string) (map[string]error, error) {
+	if s.BucketName == "" {
+		return nil, fmt.Errorf("delete files failed, bucket name empty")
+	}
+	failed := make(map[string]error)
+	limit := s.MaxConcurrency
+	if limit <= 0 {
+		limit = len(filenames)
+	}
+	usema := make(chan struct{}, limit)
+	var (
+		wg sync.WaitGroup
+		em sync.Mutex
+	)
+	for _, filename := range filenames {
+		usema <- struct{}{}
+		wg.Add(1)
+		go func(filename string) {
+			defer func() {
+				wg.Done()
+				<-usema
+			}()
+			if err := s.Delete(filename); err != nil {
+				em.Lock()
+				failed[filename] = err
+				em.Unlock()
+			}
+		}(filename)
+	}
+	wg.Wait()
+	return failed, nil
+}
+
+// Copy srcKey to dstKey within the bucket, bytes stay on the server
+func (s *UfileStorage) Copy(srcKey, dstKey string) error {
+	return s.CopyContext(context.Background(), srcKey, dstKey)
+}
+
+// Copy srcKey to dstKey, the request is cancelled once ctx is done
+func (s *UfileStorage) CopyContext(ctx context.Context, srcKey, dstKey string) error {
+	// sign
+	sign := s.signheader("PUT", "", "", s.BucketName, dstKey)
+	auth := "UCloud" + " " + s.PublicKey + ":" + sign
+	client := s.client()
+	url := s.requestURL(s.BucketName, dstKey, "")
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", auth)
+	req.Header.Add("X-Ufile-Copy-Source", "/"+s.BucketName+"/"+srcKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == 404 {
+		return fmt.Errorf("copy file failed, source %s %w", srcKey, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("copy file failed, %w", statusError("copy", resp, body))
+	}
+	return nil
+}
+
+// Copy src to dst then delete src, dst is kept when src can't be deleted
+func (s *UfileStorage) Move(src, dst string) error {
+	return s.MoveContext(context.Background(), src, dst)
+}
+
+func (s *UfileStorage) MoveContext(ctx context.Context, src, dst string) error {
+	if err := s.CopyContext(ctx, src, dst); err != nil {
+		return err
+	}
+	if err := s.DeleteContext(ctx, src); err != nil {
+		return fmt.Errorf("move file failed, %s copied to %s but not deleted, %w", src, dst, err)
+	}
+	return nil
+}
+
+// object metadata from a HEAD request, nil without error when the
+// object does not exist
+func (s *UfileStorage) head(ctx context.Context, filename string) (*ObjectInfo, error) {
+	// sign
+	sign := s.signheader("HEAD", "", "", s.BucketName, filename)
+	auth := "UCloud" + " " + s.PublicKey + ":" + sign
+	client := s.client()
+	url := s.requestURL(s.BucketName, filename, "")
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", auth)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return nil, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("head file failed, %w", statusError("head", resp, nil))
+	}
+	info := &ObjectInfo{
+		Key:  filename,
+		Size: resp.ContentLength,
+		ETag: strings.Trim(resp.Header.Get("ETag"), `"`),
+	}
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		info.LastModified, _ = http.ParseTime(lm)
+	}
+	for k, v := range resp.Header {
+		if strings.HasPrefix(k, metaPrefix) && len(v) > 0 {
+			if info.Meta == nil {
+				info.Meta = make(map[string]string)
+			}
+			info.Meta[strings.ToLower(k[len(metaPrefix):])] = v[0]
+		}
+	}
+	return info, nil
+}
+
+// User metadata of filename, keys are lower case
+func (s *UfileStorage) GetMeta(filename string) (map[string]string, error) {
+	info, err := s.Head(filename)
+	if err != nil {
+		return nil, err
+	}
+	if info.Meta == nil {
+		return map[string]string{}, nil
+	}
+	return info.Meta, nil
+}
+
+// Report whether filename exists
+func (s *UfileStorage) Exists(filename string) (bool, error) {
+	return s.ExistsContext(context.Background(), filename)
+}
+
+// Report whether filename exists, the request is cancelled once ctx is done
+func (s *UfileStorage) ExistsContext(ctx context.Context, filename string) (bool, error) {
+	info, err := s.head(ctx, filename)
+	if err != nil {
+		return false, err
+	}
+	return info != nil, nil
+}
+
+// Size, ETag and modification time of filename
+func (s *UfileStorage) Head(filename string) (*ObjectInfo, error) {
+	return s.HeadContext(context.Background(), filename)
+}
+
+// Metadata of filename, the request is cancelled once ctx is done
+func (s *UfileStorage) HeadContext(ctx context.Context, filename string) (*ObjectInfo, error) {
+	info, err := s.head(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, fmt.Errorf("head file failed, %s %w", filename, ErrNotFound)
+	}
+	return info, nil
+}
+
 type fileItem struct {
 	BucketName string
 	FileName   string
@@ -268,13 +964,27 @@ type fileList struct {
 }
 
 func (s *UfileStorage) PrefixFileList(prefix string) (*fileList, error) {
+	return s.listFiles(context.Background(), prefix, "", 0)
+}
+
+// one page of files with prefix after marker, limit <= 0 lets the server decide
+func (s *UfileStorage) listFiles(ctx context.Context, prefix, marker string, limit int) (*fileList, error) {
 	// sign
-	sign := s.signheader("GET", "", s.BucketName, "")
+	sign := s.signheader("GET", "", "", s.BucketName, "")
 	auth := "UCloud" + " " + s.PublicKey + ":" + sign
-	client := &http.Client{}
-	url := "http://" + s.BucketName + SUFFIX + "/?list&prefix=" + prefix
-	req, err := http.NewRequest("GET", url, nil)
-
+	client := s.client()
+	query := "list&prefix=" + url.QueryEscape(prefix)
+	if marker != "" {
+		query += "&marker=" + url.QueryEscape(marker)
+	}
+	if limit > 0 {
+		query += "&limit=" + strconv.Itoa(limit)
+	}
+	url := s.requestURL(s.BucketName, "", query)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Add("Authorization", auth)
 
 	resp, err := client.Do(req)
@@ -287,7 +997,7 @@ func (s *UfileStorage) PrefixFileList(prefix string) (*fileList, error) {
 		return nil, err
 	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("PrefixFileList failed, %s", string(body))
+		return nil, fmt.Errorf("PrefixFileList failed, %w", statusError("list", resp, body))
 	}
 	var res fileList
 	if err := json.Unmarshal(body, &res); err != nil {
@@ -296,67 +1006,236 @@ func (s *UfileStorage) PrefixFileList(prefix string) (*fileList, error) {
 	return &res, nil
 }
 
-func (s *UfileStorage) getFile(filename, brange string) ([]byte, int, error) {
+// List one page of objects with prefix after marker, at most limit of them,
+// pass NextMarker of the result to get the next page, it is empty on the last one
+func (s *UfileStorage) List(prefix string, marker string, limit int) (*ListResult, error) {
+	return s.ListContext(context.Background(), prefix, marker, limit)
+}
+
+func (s *UfileStorage) ListContext(ctx context.Context, prefix string, marker string, limit int) (*ListResult, error) {
+	fl, err := s.listFiles(ctx, prefix, marker, limit)
+	if err != nil {
+		return nil, err
+	}
+	res := &ListResult{
+		Objects:    make([]ObjectInfo, 0, len(fl.DataSet)),
+		NextMarker: fl.NextMarker,
+	}
+	for _, f := range fl.DataSet {
+		res.Objects = append(res.Objects, ObjectInfo{
+			Key:          f.FileName,
+			Size:         int64(f.Size),
+			ETag:         f.Hash,
+			LastModified: time.Unix(int64(f.ModifyTime), 0),
+		})
+	}
+	return res, nil
+}
+
+// List all objects with prefix, following markers page by page
+func (s *UfileStorage) ListAll(prefix string) ([]ObjectInfo, error) {
+	var all []ObjectInfo
+	marker := ""
+	for {
+		res, err := s.List(prefix, marker, 0)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, res.Objects...)
+		if res.NextMarker == "" || res.NextMarker == marker {
+			return all, nil
+		}
+		marker = res.NextMarker
+	}
+}
+
+// one ranged GET
+type getResult struct {
+	body     []byte
+	size     int    // size of the whole object
+	partial  bool   // whether the server honoured the range, 200 carries the whole object
+	encoding string // Content-Encoding of the object
+}
+
+// get brange of filename
+func (s *UfileStorage) getFile(ctx context.Context, filename, brange string) (*getResult, error) {
 	// sign
-	sign := s.signheader("GET", "", s.BucketName, filename)
+	sign := s.signheader("GET", "", "", s.BucketName, filename)
 	auth := "UCloud" + " " + s.PublicKey + ":" + sign
-	client := &http.Client{}
-	url := "http://" + s.BucketName + SUFFIX + "/" + filename
-	req, err := http.NewRequest("GET", url, nil)
-
+	client := s.client()
+	url := s.requestURL(s.BucketName, filename, "")
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Add("Authorization", auth)
 	req.Header.Add("Range", brange)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	body, err := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	if resp.StatusCode != 206 && resp.StatusCode != 200 {
-		return nil, 0, fmt.Errorf("getFile failed, %s", string(body))
+		return nil, fmt.Errorf("getFile failed, %w", statusError("get", resp, body))
 	}
-	size := 0
-	if resp.StatusCode == 200 {
-		// complete
-		size, _ = strconv.Atoi(resp.Header.Get("Content-Length"))
-	} else if resp.StatusCode == 206 {
-		// partial
-		size, _ = strconv.Atoi(strings.Split(resp.Header.Get("Content-Range"), "/")[1])
+	size := len(body)
+	if resp.StatusCode == 206 {
+		// partial, Content-Range: bytes 0-1023/4096
+		cr := resp.Header.Get("Content-Range")
+		i := strings.LastIndex(cr, "/")
+		if i < 0 {
+			return nil, fmt.Errorf("getFile failed, invalid Content-Range %q", cr)
+		}
+		if size, err = strconv.Atoi(cr[i+1:]); err != nil {
+			return nil, fmt.Errorf("getFile failed, invalid Content-Range %q", cr)
+		}
 	}
-	return body, size, nil
+	return &getResult{
+		body:     body,
+		size:     size,
+		partial:  resp.StatusCode == 206,
+		encoding: resp.Header.Get("Content-Encoding"),
+	}, nil
 }
 
 func (s *UfileStorage) Fetch(filename string) ([]byte, error) {
-	b, size, err := s.getFile(filename, "bytes=0-"+strconv.Itoa(MAX_GET_SIZE-1))
+	return s.FetchContext(context.Background(), filename)
+}
+
+// Fetch filename, content beyond the first MAX_GET_SIZE bytes is got in
+// PARTIAL_SIZE ranges, at most MaxConcurrency at a time. A server ignoring
+// Range sends everything in the first response.
+func (s *UfileStorage) FetchContext(ctx context.Context, filename string) ([]byte, error) {
+	first, err := s.getFile(ctx, filename, "bytes=0-"+strconv.Itoa(MAX_GET_SIZE-1))
 	if err != nil {
-		return b, err
+		return nil, err
 	}
+	b, size := first.body, first.size
 	lb := len(b)
-	if lb == size {
+	if lb >= size {
 		// downloaded
-		return b, nil
+		return s.decode(b, first.encoding)
 	}
 	// partial
-	size -= lb
-	num := size / PARTIAL_SIZE
-	bar := pb.StartNew(num+1)
-	// TODO concurrency
-	for i := 0; i <= num; i++ {
-		brange := "bytes="
-		brange += strconv.Itoa(i*PARTIAL_SIZE+lb) + "-"
-		brange += strconv.Itoa((i+1)*PARTIAL_SIZE + lb - 1)
-		bp, _, err := s.getFile(filename, brange)
-		if err != nil {
-			logs.Error(err)
+	num := (size - lb + PARTIAL_SIZE - 1) / PARTIAL_SIZE
+	bar := pb.StartNew(num)
+	defer bar.Finish()
+	parts, err := s.getRanges(ctx, filename, lb, size, num, bar)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("Fetch %s failed, got %d bytes, expect %d", filename, len(b), size)
+	}
+	return s.decode(b, first.encoding)
+}
+
+// get num PARTIAL_SIZE ranges of filename from offset on concurrently,
+// the first failure cancels the rest
+func (s *UfileStorage) getRanges(ctx context.Context, filename string, offset, size, num int, bar *pb.ProgressBar) ([][]byte, error) {
+	gctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	limit := s.MaxConcurrency
+	if limit <= 0 {
+		limit = num
+	}
+	usema := make(chan struct{}, limit)
+	parts := make([][]byte, num)
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+		ferr error
+	)
+	for i := 0; i < num && gctx.Err() == nil; i++ {
+		select {
+		case usema <- struct{}{}:
+		case <-gctx.Done():
 			continue
 		}
-		b = append(b, bp...)
-		bar.Increment()
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				wg.Done()
+				<-usema
+			}()
+			start := i*PARTIAL_SIZE + offset
+			end := start + PARTIAL_SIZE - 1
+			if end >= size {
+				end = size - 1
+			}
+			part, err := s.getFile(gctx, filename, "bytes="+strconv.Itoa(start)+"-"+strconv.Itoa(end))
+			if err != nil {
+				// a missing range would leave a hole in the content
+				once.Do(func() {
+					ferr = err
+					cancel()
+				})
+				return
+			}
+			parts[i] = part.body
+			bar.Increment()
+		}(i)
+	}
+	wg.Wait()
+	if ferr != nil {
+		return nil, ferr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// gunzip b when it is gzip encoded and Gzip is set
+func (s *UfileStorage) decode(b []byte, encoding string) ([]byte, error) {
+	if !s.Gzip || encoding != "gzip" {
+		return b, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("gunzip failed, %s", err)
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+// Fetch bytes start to end of filename, both inclusive, end past the
+// object is cut at its end, end -1 means up to the end of the object.
+// Ranges of gzipped files are of the stored bytes, they are not decoded.
+func (s *UfileStorage) FetchRange(filename string, start, end int64) ([]byte, error) {
+	return s.FetchRangeContext(context.Background(), filename, start, end)
+}
+
+func (s *UfileStorage) FetchRangeContext(ctx context.Context, filename string, start, end int64) ([]byte, error) {
+	if start < 0 || end < -1 || end != -1 && start > end {
+		return nil, fmt.Errorf("invalid range %d-%d", start, end)
+	}
+	rng := "bytes=" + strconv.FormatInt(start, 10) + "-"
+	if end >= 0 {
+		rng += strconv.FormatInt(end, 10)
+	}
+	res, err := s.getFile(ctx, filename, rng)
+	if err != nil {
+		return nil, err
+	}
+	b := res.body
+	if res.partial {
+		return b, nil
+	}
+	// range ignored, cut it from the whole object
+	if start >= int64(len(b)) {
+		return nil, fmt.Errorf("FetchRange %s failed, start %d beyond size %d", filename, start, len(b))
+	}
+	if end < 0 || end >= int64(len(b)) {
+		end = int64(len(b)) - 1
 	}
-	bar.Finish()
-	return b, nil
+	return b[start : end+1], nil
 }
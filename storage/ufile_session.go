@@ -0,0 +1,243 @@
+package rrstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// A multipart upload driven by the caller, it marshals to json so an
+// upload can be resumed by another process with ResumeMultipart
+type MultipartSession struct {
+	UploadId string
+	BlkSize  int // size of every part but the last
+	Bucket   string
+	Key      string
+	ETags    []string // indexed by part number, "" for parts not uploaded yet
+
+	mu sync.Mutex
+	s  *UfileStorage
+}
+
+// Start a multipart upload of filename, parts are sent with UploadPart
+func (s *UfileStorage) BeginMultipart(filename string) (*MultipartSession, error) {
+	return s.BeginMultipartContext(context.Background(), filename)
+}
+
+func (s *UfileStorage) BeginMultipartContext(ctx context.Context, filename string) (*MultipartSession, error) {
+	info, err := s.initiateMultipartUpload(ctx, filename, nil)
+	if err != nil {
+		return nil, err
+	}
+	if info.BlkSize <= 0 {
+		return nil, fmt.Errorf("initiateMultipartUpload failed, invalid BlkSize %d", info.BlkSize)
+	}
+	return &MultipartSession{
+		UploadId: info.UploadId,
+		BlkSize:  info.BlkSize,
+		Bucket:   info.Bucket,
+		Key:      info.Key,
+		s:        s,
+	}, nil
+}
+
+// Continue a session restored from json with s
+func (s *UfileStorage) ResumeMultipart(sess *MultipartSession) *MultipartSession {
+	sess.s = s
+	return sess
+}
+
+func (m *MultipartSession) info() *initResponse {
+	return &initResponse{
+		UploadId: m.UploadId,
+		BlkSize:  m.BlkSize,
+		Bucket:   m.Bucket,
+		Key:      m.Key,
+	}
+}
+
+// Upload part n, counted from 0, data must be BlkSize long except for the
+// last part, parts may be uploaded concurrently and in any order
+func (m *MultipartSession) UploadPart(n int, data []byte) (string, error) {
+	return m.UploadPartContext(context.Background(), n, data)
+}
+
+func (m *MultipartSession) UploadPartContext(ctx context.Context, n int, data []byte) (string, error) {
+	if m.s == nil {
+		return "", fmt.Errorf("session %s has no storage, use ResumeMultipart", m.UploadId)
+	}
+	if n < 0 {
+		return "", fmt.Errorf("invalid part number %d", n)
+	}
+	_, etag, err := m.s.uploadPart(ctx, data, m.info(), n)
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.ETags) <= n {
+		m.ETags = append(m.ETags, "")
+	}
+	m.ETags[n] = etag
+	return etag, nil
+}
+
+// Finish the upload, every part from 0 to the last one uploaded must be there
+func (m *MultipartSession) Complete() error {
+	return m.CompleteContext(context.Background())
+}
+
+func (m *MultipartSession) CompleteContext(ctx context.Context) error {
+	if m.s == nil {
+		return fmt.Errorf("session %s has no storage, use ResumeMultipart", m.UploadId)
+	}
+	m.mu.Lock()
+	etags := append([]string(nil), m.ETags...)
+	m.mu.Unlock()
+	if len(etags) == 0 {
+		return fmt.Errorf("session %s has no parts", m.UploadId)
+	}
+	for n, etag := range etags {
+		if etag == "" {
+			return fmt.Errorf("session %s misses part %d", m.UploadId, n)
+		}
+	}
+	_, err := m.s.finishMultipartUpload(ctx, m.info(), strings.Join(etags, ","))
+	return err
+}
+
+// Drop the upload and its parts
+func (m *MultipartSession) Abort() error {
+	if m.s == nil {
+		return fmt.Errorf("session %s has no storage, use ResumeMultipart", m.UploadId)
+	}
+	return m.s.abortMultipartUpload(m.info())
+}
+
+// State of an upload saved by SaveCheckpoint, written as json
+type uploadCheckpoint struct {
+	UploadId string
+	Bucket   string
+	Key      string
+	Size     int64
+	BlkSize  int
+	ETags    []string // indexed by part number, "" for parts not uploaded yet
+}
+
+// rewrite the checkpoint from the start, it only grows so no stale bytes
+// are left behind
+func (c *uploadCheckpoint) writeTo(w io.WriteSeeker) error {
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(c)
+}
+
+// Save content like Save, large content is uploaded in parts and the upload
+// state is written to cp after every part. A failed upload is left on the
+// server so ResumeUpload can finish it from cp.
+func (s *UfileStorage) SaveCheckpoint(content []byte, filename string, cp io.ReadWriteSeeker) error {
+	return s.SaveCheckpointContext(context.Background(), content, filename, cp)
+}
+
+func (s *UfileStorage) SaveCheckpointContext(ctx context.Context, content []byte, filename string, cp io.ReadWriteSeeker) error {
+	if len(content) <= s.maxPutSize() {
+		_, err := s.upload(ctx, content, filename, nil)
+		return err
+	}
+	info, err := s.initiateMultipartUpload(ctx, filename, nil)
+	if err != nil {
+		return err
+	}
+	if info.BlkSize <= 0 {
+		return fmt.Errorf("initiateMultipartUpload failed, invalid BlkSize %d", info.BlkSize)
+	}
+	c := &uploadCheckpoint{
+		UploadId: info.UploadId,
+		Bucket:   info.Bucket,
+		Key:      info.Key,
+		Size:     int64(len(content)),
+		BlkSize:  info.BlkSize,
+		ETags:    make([]string, (len(content)+info.BlkSize-1)/info.BlkSize),
+	}
+	if err := c.writeTo(cp); err != nil {
+		return fmt.Errorf("write checkpoint failed, %s", err)
+	}
+	return s.uploadCheckpointed(ctx, content, c, cp)
+}
+
+// Continue an upload of content started by SaveCheckpoint, parts recorded
+// in cp are not sent again
+func (s *UfileStorage) ResumeUpload(content []byte, filename string, cp io.ReadWriteSeeker) error {
+	return s.ResumeUploadContext(context.Background(), content, filename, cp)
+}
+
+func (s *UfileStorage) ResumeUploadContext(ctx context.Context, content []byte, filename string, cp io.ReadWriteSeeker) error {
+	if _, err := cp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var c uploadCheckpoint
+	if err := json.NewDecoder(cp).Decode(&c); err != nil {
+		return fmt.Errorf("read checkpoint failed, %s", err)
+	}
+	if c.Key != filename {
+		return fmt.Errorf("checkpoint is for %s, not %s", c.Key, filename)
+	}
+	if c.Size != int64(len(content)) || c.BlkSize <= 0 ||
+		len(c.ETags) != (len(content)+c.BlkSize-1)/c.BlkSize {
+		return fmt.Errorf("checkpoint of %s does not match content, size %d, block size %d, %d parts",
+			filename, c.Size, c.BlkSize, len(c.ETags))
+	}
+	return s.uploadCheckpointed(ctx, content, &c, cp)
+}
+
+// upload parts missing in c, recording each one in cp, then finish
+func (s *UfileStorage) uploadCheckpointed(ctx context.Context, content []byte, c *uploadCheckpoint, cp io.WriteSeeker) error {
+	info := &initResponse{
+		UploadId: c.UploadId,
+		BlkSize:  c.BlkSize,
+		Bucket:   c.Bucket,
+		Key:      c.Key,
+	}
+	size := len(content)
+	var mu sync.Mutex
+	etags, err := uploadConcurrently(ctx, s.MaxConcurrency, c.BlkSize,
+		func(n, blkSize int) ([]byte, error) {
+			if n*blkSize >= size {
+				return nil, io.EOF
+			}
+			end := (n + 1) * blkSize
+			if end > size {
+				end = size
+			}
+			return content[n*blkSize : end], nil
+		},
+		func(ctx context.Context, n int, part []byte) (string, error) {
+			mu.Lock()
+			etag := c.ETags[n]
+			mu.Unlock()
+			if etag != "" {
+				// uploaded before
+				return etag, nil
+			}
+			_, etag, err := s.uploadPart(ctx, part, info, n)
+			if err != nil {
+				return "", err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			c.ETags[n] = etag
+			if err := c.writeTo(cp); err != nil {
+				return "", fmt.Errorf("write checkpoint failed, %s", err)
+			}
+			return etag, nil
+		}, nil)
+	if err != nil {
+		return err
+	}
+	_, err = s.finishMultipartUpload(ctx, info, strings.Join(etags, ","))
+	return err
+}
@@ -0,0 +1,106 @@
+package rrstorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestUfileMultipartSession(t *testing.T) {
+	f := newFakeUfile(512)
+	s, done := newTestUfile(t, f)
+	defer done()
+
+	content := testContent(1300)
+	sess, err := s.BeginMultipart("a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.BlkSize != 512 || sess.UploadId != "uid" {
+		t.Fatalf("unexpected session %+v", sess)
+	}
+	if _, err := sess.UploadPart(0, content[:512]); err != nil {
+		t.Fatal(err)
+	}
+	// the last part first, then crash
+	if _, err := sess.UploadPart(2, content[1024:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.Complete(); err == nil {
+		t.Fatal("expect error completing with part 1 missing")
+	}
+	b, err := json.Marshal(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var restored MultipartSession
+	if err := json.Unmarshal(b, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.UploadPart(1, content[512:1024]); err == nil {
+		t.Fatal("expect error using a session without storage")
+	}
+	resumed := s.ResumeMultipart(&restored)
+	if _, err := resumed.UploadPart(1, content[512:1024]); err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.Complete(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.objects["a.bin"], content) {
+		t.Fatal("stored object differs from content")
+	}
+	if f.etags != "etag-0,etag-1,etag-2" {
+		t.Fatalf("finish got etags %q", f.etags)
+	}
+}
+
+func TestUfileResumeUpload(t *testing.T) {
+	f := newFakeUfile(512)
+	s, done := newTestUfile(t, f)
+	defer done()
+	s.MaxPutSize = 1024
+	s.MaxRetries = 0
+	s.SetMaxConcurrency(1)
+
+	cp, err := ioutil.TempFile("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(cp.Name())
+	defer cp.Close()
+
+	content := testContent(512*4 + 100)
+	f.failPart = 2
+	if err := s.SaveCheckpoint(content, "a.bin", cp); err == nil {
+		t.Fatal("expect error from failed part")
+	}
+	if f.aborted || f.finished {
+		t.Fatal("checkpointed upload should be left open")
+	}
+	if f.puts[0] != 1 || f.puts[1] != 1 || len(f.puts) != 2 {
+		t.Fatalf("unexpected parts before failure %v", f.puts)
+	}
+
+	if err := s.ResumeUpload(content[:100], "a.bin", cp); err == nil {
+		t.Fatal("expect error resuming with other content")
+	}
+	if err := s.ResumeUpload(content, "b.bin", cp); err == nil {
+		t.Fatal("expect error resuming another file")
+	}
+	f.failPart = -1
+	if err := s.ResumeUpload(content, "a.bin", cp); err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < 5; n++ {
+		if f.puts[n] != 1 {
+			t.Fatalf("part %d uploaded %d times", n, f.puts[n])
+		}
+	}
+	if !bytes.Equal(f.objects["a.bin"], content) {
+		t.Fatal("stored object differs from content")
+	}
+}
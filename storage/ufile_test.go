@@ -0,0 +1,1574 @@
+package rrstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUfile is a minimal ufile server recording what it receives
+type fakeUfile struct {
+	blkSize   int
+	failPart  int  // part number answered with 500, -1 for none
+	shuffle   bool // delay low part numbers so parts finish out of order
+	listLimit int  // page size of listings when the request has none, 0 for all
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	meta     map[string]http.Header // X-Ufile-Meta-* headers by key
+	parts    map[int][]byte
+	puts     map[int]int // times each part was uploaded
+	etags    string      // body of finish request
+	finished bool
+	aborted  bool
+}
+
+func newFakeUfile(blkSize int) *fakeUfile {
+	return &fakeUfile{
+		blkSize:  blkSize,
+		failPart: -1,
+		objects:  make(map[string][]byte),
+		meta:     make(map[string]http.Header),
+		parts:    make(map[int][]byte),
+		puts:     make(map[int]int),
+	}
+}
+
+func (f *fakeUfile) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key := r.URL.Path[1:]
+	body, _ := ioutil.ReadAll(r.Body)
+	if (r.Method == "PUT" && r.URL.RawQuery == "") || r.URL.RawQuery == "uploads" {
+		meta := http.Header{}
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, "X-Ufile-Meta-") || k == "Content-Encoding" {
+				meta[k] = v
+			}
+		}
+		f.mu.Lock()
+		f.meta[key] = meta
+		f.mu.Unlock()
+	}
+	switch {
+	case r.Method == "POST" && q.Get("uploadId") == "" && r.URL.RawQuery == "uploads":
+		b, _ := json.Marshal(&initResponse{
+			UploadId: "uid",
+			BlkSize:  f.blkSize,
+			Bucket:   "bucket",
+			Key:      key,
+		})
+		w.Write(b)
+	case r.Method == "PUT" && q.Get("uploadId") != "":
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		if n == f.failPart {
+			http.Error(w, "part failed", http.StatusInternalServerError)
+			return
+		}
+		if f.shuffle {
+			time.Sleep(time.Duration(64-n%64) * time.Millisecond)
+		}
+		f.mu.Lock()
+		f.parts[n] = body
+		f.puts[n]++
+		f.mu.Unlock()
+		w.Header().Set("ETag", fmt.Sprintf("etag-%d", n))
+		fmt.Fprintf(w, `{"PartNumber":%d}`, n)
+	case r.Method == "POST" && q.Get("uploadId") != "":
+		f.mu.Lock()
+		f.etags = string(body)
+		f.finished = true
+		var whole []byte
+		for i := 0; i < len(f.parts); i++ {
+			whole = append(whole, f.parts[i]...)
+		}
+		f.objects[key] = whole
+		f.mu.Unlock()
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(whole)))
+		fmt.Fprintf(w, `{"Bucket":"bucket","Key":"%s","FileSize":%d}`, key, len(whole))
+	case r.Method == "PUT" && r.URL.RawQuery == "" && r.Header.Get("X-Ufile-Copy-Source") != "":
+		src := strings.TrimPrefix(r.Header.Get("X-Ufile-Copy-Source"), "/bucket/")
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		b, ok := f.objects[src]
+		if !ok {
+			http.Error(w, "source not found", http.StatusNotFound)
+			return
+		}
+		f.objects[key] = b
+	case r.Method == "PUT" && r.URL.RawQuery == "":
+		f.mu.Lock()
+		f.objects[key] = body
+		f.mu.Unlock()
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(body)))
+	case r.Method == "GET" && strings.HasPrefix(r.URL.RawQuery, "list"):
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, q.Get("prefix")) && k > q.Get("marker") {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		limit := f.listLimit
+		if n, _ := strconv.Atoi(q.Get("limit")); n > 0 {
+			limit = n
+		}
+		res := fileList{BucketName: "bucket", DataSet: []fileItem{}}
+		if limit > 0 && len(keys) > limit {
+			keys = keys[:limit]
+			res.NextMarker = keys[limit-1]
+		}
+		for _, k := range keys {
+			res.DataSet = append(res.DataSet, fileItem{
+				BucketName: "bucket",
+				FileName:   k,
+				Hash:       fmt.Sprintf("%x", md5.Sum(f.objects[k])),
+				Size:       len(f.objects[k]),
+				ModifyTime: 1500000000,
+			})
+		}
+		b, _ := json.Marshal(&res)
+		w.Write(b)
+	case r.Method == "GET" && r.URL.RawQuery == "":
+		f.mu.Lock()
+		b, ok := f.objects[key]
+		f.mu.Unlock()
+		if !ok {
+			http.Error(w, "object not found", http.StatusNotFound)
+			return
+		}
+		f.mu.Lock()
+		if ce := f.meta[key].Get("Content-Encoding"); ce != "" {
+			w.Header().Set("Content-Encoding", ce)
+		}
+		f.mu.Unlock()
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(b))
+	case r.Method == "DELETE" && q.Get("uploadId") != "":
+		f.mu.Lock()
+		f.aborted = true
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "HEAD" && r.URL.RawQuery == "":
+		f.mu.Lock()
+		b, ok := f.objects[key]
+		f.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(b)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		f.mu.Lock()
+		for k, v := range f.meta[key] {
+			w.Header()[k] = v
+		}
+		f.mu.Unlock()
+	case r.Method == "DELETE" && r.URL.RawQuery == "":
+		f.mu.Lock()
+		_, ok := f.objects[key]
+		delete(f.objects, key)
+		f.mu.Unlock()
+		if !ok {
+			http.Error(w, "object not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// client sending all requests to h, whatever host the url says,
+// https requests go to a tls server and http ones to a plain server
+func newTestClient(h http.Handler) (*http.Client, func()) {
+	ts := httptest.NewServer(h)
+	tts := httptest.NewTLSServer(h)
+	c := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				if strings.HasSuffix(addr, ":443") {
+					return net.Dial("tcp", tts.Listener.Addr().String())
+				}
+				return net.Dial("tcp", ts.Listener.Addr().String())
+			},
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	return c, func() {
+		ts.Close()
+		tts.Close()
+	}
+}
+
+// storage whose requests all land on h
+func newTestUfile(t *testing.T, h http.Handler) (*UfileStorage, func()) {
+	s := CreateUfileStorage("pub", "pri", "bucket", 4)
+	s.RetryBackoff = time.Millisecond
+	c, done := newTestClient(h)
+	s.HTTPClient = c
+	return s, done
+}
+
+func testContent(size int) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+func TestUfileMultipartPartRanges(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	s, done := newTestUfile(t, f)
+	defer done()
+	content := testContent(MAX_PUT_SIZE + 3<<20)
+	if err := s.Save(content, "big.bin"); err != nil {
+		t.Fatal(err)
+	}
+	num := (len(content) + f.blkSize - 1) / f.blkSize
+	if len(f.parts) != num {
+		t.Fatalf("expect %d parts, got %d", num, len(f.parts))
+	}
+	for n := 0; n < num; n++ {
+		end := (n + 1) * f.blkSize
+		if end > len(content) {
+			end = len(content)
+		}
+		if !bytes.Equal(f.parts[n], content[n*f.blkSize:end]) {
+			t.Fatalf("part %d does not match content[%d:%d]", n, n*f.blkSize, end)
+		}
+		if f.puts[n] != 1 {
+			t.Fatalf("part %d uploaded %d times", n, f.puts[n])
+		}
+	}
+}
+
+func TestUfileMultipartPartError(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	f.failPart = 3
+	s, done := newTestUfile(t, f)
+	defer done()
+	err := s.Save(testContent(MAX_PUT_SIZE+1), "big.bin")
+	if err == nil {
+		t.Fatal("expect error from failed part")
+	}
+	if !strings.Contains(err.Error(), "part failed") {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if f.finished {
+		t.Fatal("finishMultipartUpload called after a part failed")
+	}
+	if !f.aborted {
+		t.Fatal("upload not aborted after a part failed")
+	}
+}
+
+func TestUfileMultipartETagOrder(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	f.shuffle = true
+	s, done := newTestUfile(t, f)
+	defer done()
+	s.MaxConcurrency = 16
+	content := testContent(200 << 20)
+	if err := s.Save(content, "huge.bin"); err != nil {
+		t.Fatal(err)
+	}
+	num := len(content) / f.blkSize
+	etags := make([]string, num)
+	for i := range etags {
+		etags[i] = fmt.Sprintf("etag-%d", i)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.etags != strings.Join(etags, ",") {
+		t.Fatalf("etags out of order, %s", f.etags)
+	}
+}
+
+func TestUfileFetch(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	s, done := newTestUfile(t, f)
+	defer done()
+
+	small := testContent(1024)
+	// larger than one ranged get, not a multiple of PARTIAL_SIZE
+	large := testContent(MAX_GET_SIZE + 2*PARTIAL_SIZE + 7)
+	f.objects["small.bin"] = small
+	f.objects["large.bin"] = large
+	for key, want := range map[string][]byte{"small.bin": small, "large.bin": large} {
+		b, err := s.Fetch(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, want) {
+			t.Fatalf("%s: fetched %d bytes not equal to stored %d bytes", key, len(b), len(want))
+		}
+	}
+	if _, err := s.Fetch("missing.bin"); err == nil {
+		t.Fatal("expect error fetching missing object")
+	}
+}
+
+func TestUfileFetchSegments(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	large := testContent(MAX_GET_SIZE + 5*PARTIAL_SIZE + 3)
+	f.objects["large.bin"] = large
+	var (
+		mu       sync.Mutex
+		inFlight int
+		max      int
+	)
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+			mu.Lock()
+			inFlight++
+			if inFlight > max {
+				max = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			defer func() {
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+			}()
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+	s.SetMaxConcurrency(3)
+
+	b, err := s.Fetch("large.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, large) {
+		t.Fatal("fetched content differs from stored")
+	}
+	if max < 2 || max > 3 {
+		t.Fatalf("%d ranges in flight, expect 2 to 3", max)
+	}
+
+	// a failed range fails the fetch
+	bad, done2 := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes="+strconv.Itoa(MAX_GET_SIZE+2*PARTIAL_SIZE)+"-"+strconv.Itoa(MAX_GET_SIZE+3*PARTIAL_SIZE-1) {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer done2()
+	if _, err := bad.Fetch("large.bin"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expect the failed range error, got %v", err)
+	}
+}
+
+func TestUfileSaveDeleteFetch(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	s, done := newTestUfile(t, f)
+	defer done()
+
+	if err := s.Save([]byte("tmp"), "tmp.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("tmp.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Fetch("tmp.txt"); err == nil {
+		t.Fatal("expect error fetching deleted object")
+	}
+
+	// 200 is success as well as 204
+	ok, done2 := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer done2()
+	if err := ok.Delete("tmp.txt"); err != nil {
+		t.Fatalf("Delete with 200: %v", err)
+	}
+}
+
+func TestUfileSaveFetchRoundTrip(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	var auth string
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			auth = r.Header.Get("Authorization")
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+
+	content := []byte("round trip")
+	if err := s.Save(content, "rt.txt"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Fetch("rt.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Fatalf("fetched %q, expect %q", b, content)
+	}
+	if expect := "UCloud pub:" + s.signheader("GET", "", "", "bucket", "rt.txt"); auth != expect {
+		t.Fatalf("GET Authorization %q, expect %q", auth, expect)
+	}
+}
+
+func TestUfileFetchPathPrefix(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	f.objects["images/a.png"] = []byte("png")
+	var path, auth string
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+
+	b, err := s.Fetch("images/a.png")
+	if err != nil || string(b) != "png" {
+		t.Fatalf("Fetch = %q, %v", b, err)
+	}
+	if path != "/images/a.png" {
+		t.Fatalf("GET path %s", path)
+	}
+	// canonical resource is /bucket/images/a.png, as for put
+	if expect := "UCloud pub:" + s.sign("GET", "", "", "", "bucket", "images/a.png"); auth != expect {
+		t.Fatalf("GET Authorization %q, expect %q", auth, expect)
+	}
+}
+
+func TestUfileDelete(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	f.objects["dir/a.txt"] = []byte("a")
+	var method, path, auth string
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, auth = r.Method, r.URL.Path, r.Header.Get("Authorization")
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+
+	if err := s.Delete("dir/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if method != "DELETE" || path != "/dir/a.txt" {
+		t.Fatalf("unexpected request %s %s", method, path)
+	}
+	if want := "UCloud pub:" + s.signheader("DELETE", "", "", "bucket", "dir/a.txt"); auth != want {
+		t.Fatalf("Authorization %q, expect %q", auth, want)
+	}
+	if _, ok := f.objects["dir/a.txt"]; ok {
+		t.Fatal("object still exists after Delete")
+	}
+	if err := s.Delete("dir/a.txt"); err == nil {
+		t.Fatal("expect error deleting missing object")
+	}
+}
+
+func TestUfileScheme(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	var tlsUsed bool
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tlsUsed = r.TLS != nil
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+
+	if err := s.Save([]byte("a"), "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if !tlsUsed {
+		t.Fatal("expect https by default")
+	}
+	s.Scheme = "http"
+	if _, err := s.Fetch("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if tlsUsed {
+		t.Fatal("expect plain http when Scheme is http")
+	}
+}
+
+func TestUfileHTTPClientTimeout(t *testing.T) {
+	stall := make(chan struct{})
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stall
+	}))
+	defer done()
+	defer close(stall)
+
+	s.HTTPClient.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	if err := s.Save([]byte("a"), "a.txt"); err == nil {
+		t.Fatal("expect timeout error from stalled server")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("request took %s, client timeout not honored", d)
+	}
+}
+
+func TestUfileTimeout(t *testing.T) {
+	stall := make(chan struct{})
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stall
+	}))
+	defer done()
+	defer close(stall)
+
+	s.MaxRetries = 0
+	s.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	_, err := s.Fetch("a.txt")
+	var nerr net.Error
+	if !errors.As(err, &nerr) || !nerr.Timeout() {
+		t.Fatalf("expect timeout error, got %v", err)
+	}
+	if d := time.Since(start); d < s.Timeout || d > 5*time.Second {
+		t.Fatalf("request returned after %s, timeout %s", d, s.Timeout)
+	}
+	if s.HTTPClient.Timeout != 0 {
+		t.Fatal("Timeout changed the supplied client")
+	}
+}
+
+// fails the first n round trips with a connection error
+type flakyTransport struct {
+	http.RoundTripper
+	n        int
+	attempts int
+}
+
+func (f *flakyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.attempts++
+	if f.attempts <= f.n {
+		return nil, fmt.Errorf("connection reset")
+	}
+	return f.RoundTripper.RoundTrip(r)
+}
+
+func TestUfileRetry(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	s, done := newTestUfile(t, f)
+	defer done()
+
+	ft := &flakyTransport{RoundTripper: s.HTTPClient.Transport, n: 2}
+	s.HTTPClient.Transport = ft
+	if err := s.Save([]byte("a"), "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if ft.attempts != 3 {
+		t.Fatalf("expect 3 attempts, got %d", ft.attempts)
+	}
+
+	// 4xx is never retried
+	var attempts int
+	s4, done4 := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer done4()
+	if err := s4.Save([]byte("a"), "a.txt"); err == nil {
+		t.Fatal("expect error for 403 response")
+	}
+	if attempts != 1 {
+		t.Fatalf("expect 1 attempt for 403, got %d", attempts)
+	}
+}
+
+func TestUfileRetry5xx(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	var attempts int
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+
+	if err := s.Save([]byte("a"), "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 || string(f.objects["a.txt"]) != "a" {
+		t.Fatalf("expect stored after 3 attempts, got %d", attempts)
+	}
+}
+
+func TestUfileProgress(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	s, done := newTestUfile(t, f)
+	defer done()
+
+	var calls, last, total int64
+	s.ProgressFunc = func(uploaded, t int64) {
+		// serialized, no lock needed
+		calls++
+		if uploaded < last {
+			panic("progress went backwards")
+		}
+		last, total = uploaded, t
+	}
+	content := testContent(MAX_PUT_SIZE + 1)
+	if err := s.Save(content, "big.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != int64(len(f.parts)) {
+		t.Fatalf("expect %d progress calls, got %d", len(f.parts), calls)
+	}
+	if last != int64(len(content)) || total != int64(len(content)) {
+		t.Fatalf("final progress %d/%d, expect %d", last, total, len(content))
+	}
+
+	// a single put reports as its body is written
+	calls, last = 0, 0
+	small := testContent(200 << 10)
+	if err := s.Save(small, "small.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if calls < 2 {
+		t.Fatalf("expect several single put progress calls, got %d", calls)
+	}
+	if last != int64(len(small)) || total != int64(len(small)) {
+		t.Fatalf("single put progress %d/%d, expect %d", last, total, len(small))
+	}
+}
+
+func TestUfileSaveStream(t *testing.T) {
+	content := testContent(MAX_PUT_SIZE + 5<<20 + 3)
+	for _, size := range []int64{int64(len(content)), -1} {
+		f := newFakeUfile(4 << 20)
+		s, done := newTestUfile(t, f)
+		// hide bytes.Reader so nothing can peek at the whole buffer
+		r := struct{ io.Reader }{bytes.NewReader(content)}
+		if err := s.SaveStream(r, size, "stream.bin"); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(f.objects["stream.bin"], content) {
+			t.Fatalf("size %d: stored object differs from streamed content", size)
+		}
+		done()
+	}
+
+	// a pipe hands over uneven chunks that straddle the part boundaries
+	for _, size := range []int64{int64(len(content)), -1} {
+		f := newFakeUfile(4 << 20)
+		s, done := newTestUfile(t, f)
+		pr, pw := io.Pipe()
+		go func() {
+			chunks := []int{1, 4093, 65537, 1<<20 + 7, 3<<20 - 5}
+			for i, off := 0, 0; off < len(content); i++ {
+				end := off + chunks[i%len(chunks)]
+				if end > len(content) {
+					end = len(content)
+				}
+				if _, err := pw.Write(content[off:end]); err != nil {
+					return
+				}
+				off = end
+			}
+			pw.Close()
+		}()
+		err := s.SaveStream(pr, size, "pipe.bin")
+		// unblock the writer if SaveStream gave up early
+		pr.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(f.objects["pipe.bin"], content) {
+			t.Fatalf("pipe size %d: stored object differs from written content", size)
+		}
+		done()
+	}
+}
+
+func TestUfileSaveStreamShort(t *testing.T) {
+	f := newFakeUfile(1024)
+	s, done := newTestUfile(t, f)
+	defer done()
+	s.MaxPutSize = 1024
+
+	r := bytes.NewReader(testContent(3000))
+	if err := s.SaveStream(r, 10000, "short.bin"); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expect io.ErrUnexpectedEOF, got %v", err)
+	}
+	if _, ok := f.objects["short.bin"]; ok {
+		t.Fatal("truncated stream committed")
+	}
+}
+
+func TestUfileGzip(t *testing.T) {
+	f := newFakeUfile(512)
+	s, done := newTestUfile(t, f)
+	defer done()
+	s.MaxPutSize = 1024
+	s.Gzip = true
+
+	text := bytes.Repeat([]byte(`{"hello":"world"}`), 1000)
+	random := make([]byte, 4000)
+	rand.New(rand.NewSource(1)).Read(random)
+	for name, content := range map[string][]byte{"text.json": text, "random.bin": random} {
+		if err := s.Save(content, name); err != nil {
+			t.Fatal(err)
+		}
+		stored := f.objects[name]
+		if len(stored) < 2 || stored[0] != 0x1f || stored[1] != 0x8b {
+			t.Fatalf("%s: stored bytes are not gzipped", name)
+		}
+		b, err := s.Fetch(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, content) {
+			t.Fatalf("%s: fetched content differs", name)
+		}
+	}
+	// text is put in one request, random bytes stay big enough for parts
+	if len(f.objects["text.json"]) > s.MaxPutSize || len(f.parts) < 2 {
+		t.Fatalf("multipart decision not made on compressed size, %d parts", len(f.parts))
+	}
+
+	s.Gzip = false
+	if b, _ := s.Fetch("text.json"); bytes.Equal(b, text) {
+		t.Fatal("fetched content decompressed with Gzip unset")
+	}
+}
+
+func TestUfileRateLimit(t *testing.T) {
+	f := newFakeUfile(512)
+	s, done := newTestUfile(t, f)
+	defer done()
+	s.MaxPutSize = 2048
+	s.RateLimit = 8000
+
+	for _, size := range []int{2000, 4096} {
+		// 2000 bytes are put at once, 4096 go in 8 concurrent parts
+		content := testContent(size)
+		start := time.Now()
+		if err := s.Save(content, "a.bin"); err != nil {
+			t.Fatal(err)
+		}
+		if d, min := time.Since(start), time.Duration(size)*time.Second/8000; d < min {
+			t.Fatalf("%d bytes uploaded in %s, expect at least %s", size, d, min)
+		}
+		if !bytes.Equal(f.objects["a.bin"], content) {
+			t.Fatalf("%d bytes: stored object differs from content", size)
+		}
+	}
+}
+
+func TestUfileContentMD5(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	var cmd5, auth string
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cmd5, auth = r.Header.Get("Content-MD5"), r.Header.Get("Authorization")
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+
+	if err := s.Save([]byte("hello"), "hello"); err != nil {
+		t.Fatal(err)
+	}
+	// md5 of "hello"
+	if cmd5 != "XUFAKrxLKna5cZ2REBfFkg==" {
+		t.Fatalf("unexpected Content-MD5 %q", cmd5)
+	}
+	want := "UCloud pub:" + s.signheader("PUT", cmd5, "application/octet-stream", "bucket", "hello")
+	if auth != want {
+		t.Fatalf("Authorization %q does not cover Content-MD5, expect %q", auth, want)
+	}
+
+	s.NoContentMD5 = true
+	if err := s.Save([]byte("hello"), "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if cmd5 != "" {
+		t.Fatalf("Content-MD5 %q sent while disabled", cmd5)
+	}
+	if want := "UCloud pub:" + s.signheader("PUT", "", "application/octet-stream", "bucket", "hello"); auth != want {
+		t.Fatalf("Authorization %q, expect %q", auth, want)
+	}
+}
+
+func TestUfileSignedDownloadURL(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Unix(1500000000, 0) }
+
+	s := CreateUfileStorage("pub", "pri", "bucket", 4)
+	u, err := s.SignedDownloadURL("dir/a.png", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HMAC-SHA1 of "GET\n\n\n1500003600\n/bucket/dir/a.png" with key "pri"
+	want := "https://bucket" + SUFFIX + "/dir/a.png?UCloudPublicKey=pub&Expires=1500003600&Signature=5A%2BsGQEi0AIrFovBXWh3bDrUAdI%3D"
+	if u != want {
+		t.Fatalf("got %s, expect %s", u, want)
+	}
+	if again, _ := s.SignedDownloadURL("dir/a.png", time.Hour); again != u {
+		t.Fatal("signature not stable for fixed inputs")
+	}
+	if _, err := s.SignedDownloadURL("dir/a.png", -time.Second); err == nil {
+		t.Fatal("expect error for negative expire")
+	}
+
+	for expire, want := range map[time.Duration]string{
+		15 * time.Minute: "1500000900",
+		2 * time.Hour:    "1500007200",
+		0:                "1500003600", // ExpireSeconds
+	} {
+		u, err := s.SignedDownloadURL("dir/a.png", expire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pu, err := url.Parse(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := pu.Query()
+		if q.Get("Expires") != want {
+			t.Fatalf("expire %s: Expires %s, expect %s", expire, q.Get("Expires"), want)
+		}
+		if q.Get("Signature") != s.sign("GET", "", "", want, "bucket", "dir/a.png") {
+			t.Fatalf("expire %s: signature does not cover Expires", expire)
+		}
+	}
+}
+
+func TestUfilePresignPutURL(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Unix(1500000000, 0) }
+
+	s := CreateUfileStorage("pub", "pri", "bucket", 4)
+	u, err := s.PresignPutURL("dir/a b.png", 10*time.Minute, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pu.Host != "bucket"+SUFFIX || pu.Path != "/dir/a b.png" {
+		t.Fatalf("unexpected url %s", u)
+	}
+	q := pu.Query()
+	if q.Get("UCloudPublicKey") != "pub" || q.Get("Expires") != "1500000600" {
+		t.Fatalf("unexpected query %v", q)
+	}
+	// recompute as the server would for a PUT with the content type
+	data := "PUT\n\nimage/png\n1500000600\n/bucket/dir/a b.png"
+	h := hmac.New(sha1.New, []byte("pri"))
+	h.Write([]byte(data))
+	if q.Get("Signature") != base64.StdEncoding.EncodeToString(h.Sum(nil)) {
+		t.Fatal("signature does not verify")
+	}
+	if _, err := s.PresignPutURL("a", -time.Second, ""); err == nil {
+		t.Fatal("expect error for negative expire")
+	}
+}
+
+func TestUfileInstanceSettings(t *testing.T) {
+	f := newFakeUfile(512)
+	var host string
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+
+	s.Endpoint = ".cn-bj.example.com"
+	s.MaxPutSize = 1024
+	content := testContent(2000)
+	if err := s.Save(content, "a.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if host != "bucket.cn-bj.example.com" {
+		t.Fatalf("unexpected host %s", host)
+	}
+	if len(f.parts) != 4 || !bytes.Equal(f.objects["a.bin"], content) {
+		t.Fatalf("expect multipart upload in 4 parts, got %d", len(f.parts))
+	}
+
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Unix(1500000000, 0) }
+	s.ExpireSeconds = 60
+	u, _ := s.SignedDownloadURL("a.bin", 0)
+	if !strings.Contains(u, "&Expires=1500000060&") {
+		t.Fatalf("ExpireSeconds not used, %s", u)
+	}
+}
+
+func TestUfileRequestURL(t *testing.T) {
+	s := CreateUfileStorage("pub", "pri", "bucket", 0)
+	if u := s.requestURL("bucket", "a/b.txt", ""); u != "https://bucket"+SUFFIX+"/a/b.txt" {
+		t.Fatalf("default endpoint url %s", u)
+	}
+	s.Endpoint = ".cn-bj.ufileos.com"
+	s.Scheme = "http"
+	if u := s.requestURL("bucket", "a.txt", "uploads"); u != "http://bucket.cn-bj.ufileos.com/a.txt?uploads" {
+		t.Fatalf("custom endpoint url %s", u)
+	}
+}
+
+func TestUfileContentType(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	var ctype, auth string
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctype, auth = r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+
+	for filename, want := range map[string]string{
+		"a.png":  "image/png",
+		"a.json": "application/json",
+		"a.zzz":  "application/octet-stream",
+	} {
+		if err := s.Save([]byte("a"), filename); err != nil {
+			t.Fatal(err)
+		}
+		if ctype != want {
+			t.Fatalf("%s: Content-Type %q, expect %q", filename, ctype, want)
+		}
+		if auth != "UCloud pub:"+s.signheader("PUT", contentMD5([]byte("a")), want, "bucket", filename) {
+			t.Fatalf("%s: signature does not cover Content-Type", filename)
+		}
+	}
+	s.ContentType = "text/plain"
+	if err := s.Save([]byte("a"), "a.png"); err != nil {
+		t.Fatal(err)
+	}
+	if ctype != "text/plain" {
+		t.Fatalf("ContentType override ignored, got %q", ctype)
+	}
+}
+
+func TestUfileMultipartContentType(t *testing.T) {
+	f := newFakeUfile(512)
+	var ctype, auth string
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["uploads"]; ok {
+			ctype, auth = r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+	s.MaxPutSize = 1024
+
+	if err := s.Save(testContent(2048), "a.png"); err != nil {
+		t.Fatal(err)
+	}
+	if ctype != "image/png" {
+		t.Fatalf("init Content-Type %q, expect image/png", ctype)
+	}
+	if auth != "UCloud pub:"+s.signheader("POST", "", "image/png", "bucket", "a.png") {
+		t.Fatal("init signature does not cover Content-Type")
+	}
+}
+
+func TestUfileExistsHead(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	f.objects["a.txt"] = []byte("hello")
+	f.objects["teapot"] = nil
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/teapot" {
+			w.WriteHeader(http.StatusTeapot)
+			return
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+
+	if ok, err := s.Exists("a.txt"); err != nil || !ok {
+		t.Fatalf("Exists(a.txt) = %v, %v", ok, err)
+	}
+	if ok, err := s.Exists("missing.txt"); err != nil || ok {
+		t.Fatalf("Exists(missing.txt) = %v, %v", ok, err)
+	}
+	if _, err := s.Exists("teapot"); err == nil {
+		t.Fatal("expect error for unexpected status")
+	}
+	info, err := s.Head("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 5 || info.ETag != fmt.Sprintf("%x", md5.Sum([]byte("hello"))) {
+		t.Fatalf("unexpected object info %+v", info)
+	}
+	if _, err := s.Head("missing.txt"); err == nil {
+		t.Fatal("expect error for missing object")
+	}
+}
+
+func TestUfileCopy(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	f.objects["src.txt"] = []byte("hello")
+	var length int64
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		length = r.ContentLength
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+
+	if err := s.Copy("src.txt", "dir/dst.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if length != 0 {
+		t.Fatalf("copy sent %d bytes of body", length)
+	}
+	if string(f.objects["dir/dst.txt"]) != "hello" {
+		t.Fatal("destination not created")
+	}
+	err := s.Copy("missing.txt", "dst.txt")
+	if err == nil || !strings.Contains(err.Error(), "missing.txt not exist") {
+		t.Fatalf("expect source not exist error, got %v", err)
+	}
+}
+
+func TestUfileMove(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	f.objects["src.txt"] = []byte("hello")
+	s, done := newTestUfile(t, f)
+	defer done()
+
+	if err := s.Move("src.txt", "dir/dst.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.objects["src.txt"]; ok {
+		t.Fatal("source left after Move")
+	}
+	if string(f.objects["dir/dst.txt"]) != "hello" {
+		t.Fatal("destination not created")
+	}
+	if err := s.Move("src.txt", "x.txt"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound moving missing file, got %v", err)
+	}
+}
+
+func TestUfileDeleteMany(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	keys := []string{"a", "b", "c", "d", "e"}
+	for _, k := range keys[:3] {
+		f.objects[k] = []byte(k)
+	}
+	s, done := newTestUfile(t, f)
+	defer done()
+	s.MaxConcurrency = 2
+
+	failed, err := s.DeleteMany(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 2 || failed["d"] == nil || failed["e"] == nil {
+		t.Fatalf("expect d and e failed, got %v", failed)
+	}
+	if len(f.objects) != 0 {
+		t.Fatalf("objects left after DeleteMany, %v", f.objects)
+	}
+}
+
+func TestUfileDeleteBatch(t *testing.T) {
+	f := newFakeUfile(4 << 20)
+	for _, k := range []string{"a", "c", "e"} {
+		f.objects[k] = []byte(k)
+	}
+	s, done := newTestUfile(t, f)
+	defer done()
+
+	failed, err := s.DeleteBatch([]string{"e", "d", "c", "b", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(failed, ",") != "d,b" {
+		t.Fatalf("expect d and b failed, got %v", failed)
+	}
+	if len(f.objects) != 0 {
+		t.Fatalf("objects left after DeleteBatch, %v", f.objects)
+	}
+}
+
+func TestUfileAbortOnFinishError(t *testing.T) {
+	f := newFakeUfile(512)
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.Query().Get("uploadId") != "" {
+			http.Error(w, "finish failed", http.StatusBadRequest)
+			return
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+	s.MaxPutSize = 1024
+
+	if err := s.Save(testContent(2000), "a.bin"); err == nil || !strings.Contains(err.Error(), "finish failed") {
+		t.Fatalf("expect finish error, got %v", err)
+	}
+	if !f.aborted {
+		t.Fatal("upload not aborted after finish failed")
+	}
+}
+
+func TestUfileRemainderPartLength(t *testing.T) {
+	f := newFakeUfile(512)
+	var (
+		mu      sync.Mutex
+		lengths = make(map[string]int64)
+	)
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := r.URL.Query().Get("partNumber"); n != "" {
+			mu.Lock()
+			lengths[n] = r.ContentLength
+			mu.Unlock()
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+	s.MaxPutSize = 1024
+
+	// 3 full parts and a 100 bytes remainder
+	content := testContent(3*512 + 100)
+	if err := s.Save(content, "a.bin"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"0": 512, "1": 512, "2": 512, "3": 100}
+	for n, l := range want {
+		if lengths[n] != l {
+			t.Fatalf("part %s sent with Content-Length %d, expect %d", n, lengths[n], l)
+		}
+	}
+	if !bytes.Equal(f.objects["a.bin"], content) {
+		t.Fatal("stored object differs from content")
+	}
+}
+
+func TestUfileZeroBlkSize(t *testing.T) {
+	f := newFakeUfile(0)
+	s, done := newTestUfile(t, f)
+	defer done()
+	s.MaxPutSize = 1024
+
+	err := s.Save(testContent(2000), "a.bin")
+	if err == nil || !strings.Contains(err.Error(), "invalid BlkSize 0") {
+		t.Fatalf("expect invalid BlkSize error, got %v", err)
+	}
+	if len(f.parts) != 0 || !f.aborted {
+		t.Fatal("expect no parts uploaded and the upload aborted")
+	}
+}
+
+func TestUfileSaveWithInfo(t *testing.T) {
+	f := newFakeUfile(512)
+	s, done := newTestUfile(t, f)
+	defer done()
+	s.MaxPutSize = 1024
+
+	// single put and multipart
+	for _, size := range []int{100, 2000} {
+		content := testContent(size)
+		info, err := s.SaveWithInfo(content, "a.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Key != "a.bin" || info.Size != int64(size) || info.ETag != fmt.Sprintf("%x", md5.Sum(content)) {
+			t.Fatalf("size %d: unexpected object info %+v", size, info)
+		}
+	}
+}
+
+func TestUfileInvalidURL(t *testing.T) {
+	f := newFakeUfile(512)
+	s, done := newTestUfile(t, f)
+	defer done()
+	s.MaxPutSize = 1024
+
+	// control characters make the url unparsable
+	s.BucketName = "bucket\x7f"
+	name := "a.bin"
+	if err := s.Save(testContent(2000), name); err == nil {
+		t.Fatal("expect error from multipart Save")
+	}
+	if _, err := s.Fetch(name); err == nil {
+		t.Fatal("expect error from Fetch")
+	}
+	if _, err := s.PrefixFileList(name); err == nil {
+		t.Fatal("expect error from PrefixFileList")
+	}
+}
+
+func TestUfileEscapeKey(t *testing.T) {
+	f := newFakeUfile(512)
+	s, done := newTestUfile(t, f)
+	defer done()
+	s.MaxPutSize = 1024
+
+	key := "my folder/ümlaut file?#%.txt"
+	// single put and multipart
+	for _, size := range []int{100, 2000} {
+		content := testContent(size)
+		if err := s.Save(content, key); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(f.objects[key], content) {
+			t.Fatalf("size %d: object not stored under raw key", size)
+		}
+		b, err := s.Fetch(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, content) {
+			t.Fatalf("size %d: fetched content differs", size)
+		}
+	}
+}
+
+func TestUfileList(t *testing.T) {
+	f := newFakeUfile(512)
+	f.listLimit = 2
+	s, done := newTestUfile(t, f)
+	defer done()
+	for _, k := range []string{"a/1", "a/2", "a/3", "b/1"} {
+		f.objects[k] = []byte(k)
+	}
+
+	res, err := s.List("a/", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Objects) != 2 || res.Objects[0].Key != "a/1" || res.NextMarker != "a/2" {
+		t.Fatalf("unexpected first page %+v", res)
+	}
+	if o := res.Objects[0]; o.Size != 3 || o.LastModified.Unix() != 1500000000 {
+		t.Fatalf("unexpected object info %+v", o)
+	}
+	res, err = s.List("a/", res.NextMarker, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Objects) != 1 || res.Objects[0].Key != "a/3" || res.NextMarker != "" {
+		t.Fatalf("unexpected second page %+v", res)
+	}
+
+	all, err := s.ListAll("a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, o := range all {
+		keys = append(keys, o.Key)
+	}
+	if strings.Join(keys, ",") != "a/1,a/2,a/3" {
+		t.Fatalf("ListAll = %v", keys)
+	}
+}
+
+func TestUfileFetchRange(t *testing.T) {
+	content := testContent(1000)
+	f := newFakeUfile(512)
+	f.objects["a.bin"] = content
+	s, done := newTestUfile(t, f)
+	defer done()
+
+	// 206 from the fake, and 200 from a server ignoring Range
+	ignore, done2 := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer done2()
+
+	for _, st := range []*UfileStorage{s, ignore} {
+		b, err := st.FetchRange("a.bin", 10, 19)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, content[10:20]) {
+			t.Fatalf("FetchRange(10, 19) = %v", b)
+		}
+		// end past the object
+		b, err = st.FetchRange("a.bin", 990, 2000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, content[990:]) {
+			t.Fatalf("FetchRange(990, 2000) = %v", b)
+		}
+		// open ended
+		b, err = st.FetchRange("a.bin", 900, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, content[900:]) {
+			t.Fatalf("FetchRange(900, -1) = %v", b)
+		}
+	}
+	var rng string
+	open, done3 := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rng = r.Header.Get("Range")
+		f.ServeHTTP(w, r)
+	}))
+	defer done3()
+	if _, err := open.FetchRange("a.bin", 900, -1); err != nil || rng != "bytes=900-" {
+		t.Fatalf("open range sent %q, %v", rng, err)
+	}
+	if _, err := s.FetchRange("a.bin", 10, -2); err == nil {
+		t.Fatal("expect error for end < -1")
+	}
+	if _, err := s.FetchRange("a.bin", 20, 10); err == nil {
+		t.Fatal("expect error for start > end")
+	}
+	if _, err := s.FetchRange("a.bin", -1, 10); err == nil {
+		t.Fatal("expect error for negative start")
+	}
+}
+
+func TestUfileFinishResponses(t *testing.T) {
+	content := testContent(2000)
+	for _, tc := range []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"200 with body", 200, `{"Bucket":"bucket","Key":"a.bin","FileSize":2000}`},
+		{"200 empty", 200, ""},
+		{"204 empty", 204, ""},
+	} {
+		f := newFakeUfile(512)
+		s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "POST" && r.URL.Query().Get("uploadId") != "" {
+				w.Header().Set("ETag", `"etag"`)
+				w.WriteHeader(tc.status)
+				io.WriteString(w, tc.body)
+				return
+			}
+			f.ServeHTTP(w, r)
+		}))
+		s.MaxPutSize = 1024
+
+		info, err := s.SaveWithInfo(content, "a.bin")
+		done()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if info.Key != "a.bin" || info.Size != 2000 || info.ETag != "etag" {
+			t.Fatalf("%s: unexpected object info %+v", tc.name, info)
+		}
+		if f.aborted {
+			t.Fatalf("%s: upload aborted", tc.name)
+		}
+	}
+}
+
+func TestUfileMeta(t *testing.T) {
+	f := newFakeUfile(512)
+	s, done := newTestUfile(t, f)
+	defer done()
+	s.MaxPutSize = 1024
+
+	// single put and multipart
+	for _, size := range []int{100, 2000} {
+		meta := map[string]string{"owner": "ops", "Build-Id": strconv.Itoa(size)}
+		if err := s.SaveWithMeta(testContent(size), "a.bin", meta); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetMeta("a.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got["owner"] != "ops" || got["build-id"] != strconv.Itoa(size) {
+			t.Fatalf("size %d: GetMeta = %v", size, got)
+		}
+	}
+	if err := s.Save([]byte("x"), "b.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.GetMeta("b.bin"); err != nil || len(got) != 0 {
+		t.Fatalf("GetMeta without meta = %v, %v", got, err)
+	}
+	if _, err := s.GetMeta("missing"); err == nil {
+		t.Fatal("expect error for missing object")
+	}
+}
+
+func TestUfileACL(t *testing.T) {
+	f := newFakeUfile(512)
+	var acls []string
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.RawQuery == "" {
+			acls = append(acls, r.Header.Get("X-Ufile-Acl"))
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+	s.MaxPutSize = 1024
+	s.MaxConcurrency = 1
+
+	if err := s.Save([]byte("a"), "default.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveWithACL([]byte("a"), "public.txt", ACL_PUBLIC_READ); err != nil {
+		t.Fatal(err)
+	}
+	s.ACL = ACL_PRIVATE
+	// put and multipart init carry the acl, finish does not
+	if err := s.Save([]byte("a"), "private.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(testContent(2000), "private.bin"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"", "public-read", "private", "private", ""}
+	if strings.Join(acls, ",") != strings.Join(want, ",") {
+		t.Fatalf("acl headers %q, expect %q", acls, want)
+	}
+}
+
+func TestUfileTypedErrors(t *testing.T) {
+	status := 0
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "denied", status)
+	}))
+	defer done()
+	s.MaxRetries = 0
+
+	status = 403
+	err := s.Save([]byte("x"), "a.bin")
+	if !errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrNotFound) {
+		t.Fatalf("403 put: %v", err)
+	}
+	var se *StorageError
+	if !errors.As(err, &se) || se.Op != "put" || se.StatusCode != 403 || se.Body != "denied\n" {
+		t.Fatalf("403 put: no StorageError in %v", err)
+	}
+	if err := s.Delete("a.bin"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("403 delete: %v", err)
+	}
+
+	status = 404
+	_, err = s.Fetch("a.bin")
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &se) || se.Op != "get" || se.StatusCode != 404 {
+		t.Fatalf("404 fetch: %v", err)
+	}
+	if _, err := s.Head("a.bin"); !errors.Is(err, ErrNotFound) || err.Error() != "head file failed, a.bin not exist" {
+		t.Fatalf("404 head: %v", err)
+	}
+	if err := s.Copy("a.bin", "b.bin"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("404 copy: %v", err)
+	}
+
+	status = 500
+	s.MaxPutSize = 1
+	err = s.Save([]byte("xx"), "a.bin")
+	if !errors.As(err, &se) || se.Op != "initiateMultipartUpload" || se.StatusCode != 500 || errors.Is(err, ErrNotFound) || errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("500 initiate: %v", err)
+	}
+}
+
+func TestUfileListEmpty(t *testing.T) {
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"BucketName":"bucket","DataSet":null}`)
+	}))
+	defer done()
+
+	res, err := s.List("none/", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Objects == nil || len(res.Objects) != 0 || res.NextMarker != "" {
+		t.Fatalf("unexpected empty listing %+v", res)
+	}
+	all, err := s.ListAll("none/")
+	if err != nil || len(all) != 0 {
+		t.Fatalf("ListAll = %v, %v", all, err)
+	}
+}
+
+func TestUfileMaxConcurrency(t *testing.T) {
+	f := newFakeUfile(512)
+	var (
+		mu       sync.Mutex
+		inFlight int
+		max      int
+	)
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("partNumber") != "" {
+			mu.Lock()
+			inFlight++
+			if inFlight > max {
+				max = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			defer func() {
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+			}()
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+	s.MaxPutSize = 1024
+	s.SetMaxConcurrency(2)
+
+	if err := s.Save(testContent(512*10), "a.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if max > 2 {
+		t.Fatalf("%d parts in flight, limit 2", max)
+	}
+	if len(f.parts) != 10 {
+		t.Fatalf("expect 10 parts, got %d", len(f.parts))
+	}
+}
+
+func TestUfileSaveContextCancel(t *testing.T) {
+	f := newFakeUfile(512)
+	started := make(chan struct{}, 16)
+	s, done := newTestUfile(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("partNumber") != "" {
+			// hold parts until the client gives up, the disconnect is
+			// only noticed once the body is consumed
+			ioutil.ReadAll(r.Body)
+			started <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer done()
+	s.MaxPutSize = 1024
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	errc := make(chan error, 1)
+	go func() { errc <- s.SaveContext(ctx, testContent(512*10), "a.bin") }()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expect context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("save not returned after cancel")
+	}
+}
@@ -1,7 +1,29 @@
 package rrstorage
 
+import (
+	"time"
+)
+
 // Gerneral storage wrapper
 type StorageWrapper interface {
 	Save([]byte, string) error // do save binary
 	Fetch(string) ([]byte, error)
+	Delete(string) error // remove binary
+	Exists(string) (bool, error)
+	Move(string, string) error // rename src to dst
+}
+
+// Object metadata
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	Meta         map[string]string // user metadata, nil when there is none
+}
+
+// One page of a listing
+type ListResult struct {
+	Objects    []ObjectInfo
+	NextMarker string // empty when there are no more pages
 }